@@ -62,12 +62,16 @@ func convertArgVal(arg APIArg, val interface{}) (
 	switch arg.ArgType {
 	case IntArg:
 		switch val.(type) {
+		case int:
+			return true, nil, val.(int)
 		case int8:
 			return true, nil, int(val.(int8))
 		case int16:
 			return true, nil, int(val.(int16))
 		case int32:
 			return true, nil, int(val.(int32))
+		case int64:
+			return true, nil, int(val.(int64))
 		case float64:
 			return true, nil, int(val.(float64))
 		case string:
@@ -80,12 +84,16 @@ func convertArgVal(arg APIArg, val interface{}) (
 	case UIntArg:
 		var uval int
 		switch val.(type) {
+		case int:
+			uval = val.(int)
 		case int8:
 			uval = int(val.(int8))
 		case int16:
 			uval = int(val.(int16))
 		case int32:
 			uval = int(val.(int32))
+		case int64:
+			uval = int(val.(int64))
 		case float64:
 			uval = int(val.(float64))
 		case string:
@@ -103,6 +111,18 @@ func convertArgVal(arg APIArg, val interface{}) (
 		return true, nil, uval
 
 	case FloatArg:
+		switch val.(type) {
+		case int:
+			return true, nil, float64(val.(int))
+		case int64:
+			return true, nil, float64(val.(int64))
+		case string:
+			f, err := strconv.ParseFloat(val.(string), 64)
+			if err == nil {
+				return true, nil, f
+			}
+			return false, nil, nil
+		}
 		return true, nil, val.(float64)
 	case StringArg:
 		return true, nil, val.(string)
@@ -127,4 +147,31 @@ func stringArgType(argType int) string {
 	case RawArg: return "raw"
 	}
 	return "unknown"
-}
\ No newline at end of file
+}
+
+// ToAPIData converts decoded JSON (or other generic) values so that
+// every nested map[string]interface{} becomes an APIData, which is
+// what NestedArg expects.
+func ToAPIData(val interface{}) interface{} {
+	switch val.(type) {
+	case map[string]interface{}:
+		var data = make(APIData)
+		for k, v := range val.(map[string]interface{}) {
+			data[k] = ToAPIData(v)
+		}
+		return data
+	case APIData:
+		var data = val.(APIData)
+		for k, v := range data {
+			data[k] = ToAPIData(v)
+		}
+		return data
+	case []interface{}:
+		var list = val.([]interface{})
+		for i, v := range list {
+			list[i] = ToAPIData(v)
+		}
+		return list
+	}
+	return val
+}
@@ -0,0 +1,83 @@
+package goservice
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+var nestedSpec = []APIArg{
+	APIArg{Name: "user", ArgType: NestedArg, Extra: []APIArg{
+		APIArg{Name: "name", ArgType: StringArg},
+	}},
+}
+
+func TestToAPIData(t *testing.T) {
+	var decoded interface{}
+	json.Unmarshal([]byte(`{"user": {"name": "bob"}, "tags": [{"a": 1}, "b"]}`), &decoded)
+
+	data, ok := ToAPIData(decoded).(APIData)
+	if !ok {
+		t.Fatalf("Not converted: %#v", decoded)
+	}
+	if _, ok := data["user"].(APIData); !ok {
+		t.Errorf("Nested map not converted: %#v", data["user"])
+	}
+	if tags := data["tags"].([]interface{}); len(tags) != 2 {
+		t.Errorf("Wrong list: %#v", tags)
+	} else if _, ok := tags[0].(APIData); !ok {
+		t.Errorf("Map in list not converted: %#v", tags[0])
+	}
+
+	ok, errors, args := Parse(nestedSpec, data)
+	if !ok {
+		t.Fatalf("Converted data doesn't parse: %v", ListToStringSlice(errors))
+	}
+	if args["user"].(APIData)["name"] != "bob" {
+		t.Errorf("Wrong args: %v", args)
+	}
+
+	if ToAPIData("plain") != "plain" {
+		t.Errorf("Scalar changed")
+	}
+}
+
+func TestGoIntArgs(t *testing.T) {
+	var spec = []APIArg{
+		APIArg{Name: "i", ArgType: IntArg},
+		APIArg{Name: "u", ArgType: UIntArg},
+	}
+
+	for _, val := range []interface{}{int(3), int8(3), int16(3), int32(3), int64(3), float64(3), "3"} {
+		ok, errors, args := Parse(spec, APIData{"i": val, "u": val})
+		if !ok {
+			t.Errorf("%T rejected: %v", val, ListToStringSlice(errors))
+			continue
+		}
+		if args["i"] != 3 || args["u"] != 3 {
+			t.Errorf("%T: wrong args %v", val, args)
+		}
+	}
+
+	if ok, _, _ := Parse(spec, APIData{"i": 1, "u": -1}); ok {
+		t.Errorf("Negative UIntArg accepted")
+	}
+}
+
+func TestFloatArgs(t *testing.T) {
+	var spec = []APIArg{APIArg{Name: "f", ArgType: FloatArg}}
+
+	for _, val := range []interface{}{float64(1.5), "1.5", int(2), int64(2)} {
+		if ok, errors, _ := Parse(spec, APIData{"f": val}); !ok {
+			t.Errorf("%T rejected: %v", val, ListToStringSlice(errors))
+		}
+	}
+
+	_, _, args := Parse(spec, APIData{"f": "1.5"})
+	if args["f"] != 1.5 {
+		t.Errorf("Wrong value: %v", args)
+	}
+
+	if ok, _, _ := Parse(spec, APIData{"f": "lots"}); ok {
+		t.Errorf("Non-numeric string accepted")
+	}
+}
@@ -0,0 +1,419 @@
+package goservice
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"code.google.com/p/go.crypto/bcrypt"
+)
+
+// Authenticator turns login credentials into a User. It returns
+// ErrNoCredentials when the credentials aren't of its kind, so the
+// auth service can try the next one, and ErrBadCredentials when they
+// are but don't check out.
+type Authenticator interface {
+	Authenticate(credentials APIData) (User, error)
+}
+
+var (
+	ErrNoCredentials = errors.New("no credentials")
+	ErrBadCredentials = errors.New("invalid credentials")
+)
+
+
+// BasicUser is a User with nothing but an id, a display name and
+// roles.
+type BasicUser struct {
+	id string
+	name string
+	roles []string
+}
+
+func NewBasicUser(id string, name string, roles... string) *BasicUser {
+	return &BasicUser{
+		id: id,
+		name: name,
+		roles: roles,
+	}
+}
+
+func (user *BasicUser) ID() string {
+	return user.id
+}
+
+func (user *BasicUser) DisplayName() string {
+	return user.name
+}
+
+func (user *BasicUser) Roles() []string {
+	return user.roles
+}
+
+// loaderOrDefault makes a BasicUser named after the id when no
+// UserLoader is given.
+func loaderOrDefault(loader UserLoader) UserLoader {
+	if loader != nil {
+		return loader
+	}
+	return func(id string) User {
+		return NewBasicUser(id, id)
+	}
+}
+
+func loadUser(loader UserLoader, id string) (User, error) {
+	var user = loader(id)
+	if user == nil {
+		return nil, ErrBadCredentials
+	}
+	return user, nil
+}
+
+
+// ------------------------------------------
+// Built-in service
+// ------------------------------------------
+
+var loginArgSpec = []APIArg {
+	APIArg{Name: "username", ArgType: StringArg, Default: ""},
+	APIArg{Name: "password", ArgType: StringArg, Default: ""},
+	APIArg{Name: "key", ArgType: StringArg, Default: ""},
+	APIArg{Name: "token", ArgType: StringArg, Default: ""},
+}
+
+// NewAuthService returns an "auth" service with login, logout and
+// whoami methods. Login tries each authenticator in turn with
+// whichever of username/password, key or token the client sent.
+func NewAuthService(authenticators... Authenticator) *Service {
+	var service = NewService("auth")
+
+	service.AddMethod("login", loginArgSpec, func(args APIData, session Session, context ServerContext) (bool, APIData) {
+		return authLogin(authenticators, args, session, context)
+	})
+	service.AddMethod("logout", []APIArg{}, authLogout)
+	service.AddMethod("whoami", []APIArg{}, authWhoami)
+
+	return service
+}
+
+func authLogin(
+	authenticators []Authenticator, args APIData,
+	session Session, context ServerContext) (bool, APIData) {
+
+	if session == nil {
+		return false, APIData{"message": "No session"}
+	}
+
+	for _, authenticator := range authenticators {
+		user, err := authenticator.Authenticate(args)
+		if err == ErrNoCredentials {
+			continue
+		}
+		if err != nil {
+			if err != ErrBadCredentials {
+				context.Log("Authentication error: %v", err)
+			}
+			return false, APIData{"message": "Invalid credentials"}
+		}
+
+		session.SetUser(user)
+		return true, userData(user)
+	}
+
+	return false, APIData{"message": "No credentials"}
+}
+
+func authLogout(args APIData, session Session, context ServerContext) (bool, APIData) {
+	if session == nil {
+		return false, APIData{"message": "No session"}
+	}
+	session.SetUser(nil)
+	return true, nil
+}
+
+func authWhoami(args APIData, session Session, context ServerContext) (bool, APIData) {
+	if session == nil || session.User() == nil {
+		return true, APIData{"loggedIn": false}
+	}
+	return true, userData(session.User())
+}
+
+func userData(user User) APIData {
+	return APIData{
+		"loggedIn": true,
+		"user": user.ID(),
+		"name": user.DisplayName(),
+	}
+}
+
+
+// ------------------------------------------
+// Passwords
+// ------------------------------------------
+
+// PasswordAuthenticator checks "username" and "password" against
+// bcrypt hashes. With a path it keeps them in a file of
+// "username:hash" lines, as written by htpasswd -B.
+type PasswordAuthenticator struct {
+	hashes map[string][]byte
+	loader UserLoader
+	path string
+	sync.RWMutex
+}
+
+// NewPasswordAuthenticator keeps hashes in memory only. loader maps a
+// username to its User; nil means a BasicUser named after it.
+func NewPasswordAuthenticator(loader UserLoader) *PasswordAuthenticator {
+	return &PasswordAuthenticator{
+		hashes: make(map[string][]byte),
+		loader: loaderOrDefault(loader),
+	}
+}
+
+// NewPasswordFileAuthenticator loads hashes from path, which need not
+// exist yet. AddUser and RemoveUser write it back.
+func NewPasswordFileAuthenticator(path string, loader UserLoader) (*PasswordAuthenticator, error) {
+	var auth = NewPasswordAuthenticator(loader)
+	auth.path = path
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return auth, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var scanner = bufio.NewScanner(file)
+	for scanner.Scan() {
+		var line = strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		var colon = strings.Index(line, ":")
+		if colon < 1 {
+			return nil, fmt.Errorf("bad password file line: %q", line)
+		}
+		auth.hashes[line[:colon]] = []byte(line[colon+1:])
+	}
+	return auth, scanner.Err()
+}
+
+// HashPassword returns a bcrypt hash suitable for a password file.
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+func (auth *PasswordAuthenticator) AddUser(username string, password string) error {
+	if username == "" || strings.ContainsAny(username, ":\n") {
+		return fmt.Errorf("invalid username: %q", username)
+	}
+
+	hash, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	auth.Lock()
+	defer auth.Unlock()
+	auth.hashes[username] = []byte(hash)
+	return auth.save()
+}
+
+func (auth *PasswordAuthenticator) RemoveUser(username string) error {
+	auth.Lock()
+	defer auth.Unlock()
+	delete(auth.hashes, username)
+	return auth.save()
+}
+
+func (auth *PasswordAuthenticator) Authenticate(credentials APIData) (User, error) {
+	username, _ := credentials["username"].(string)
+	password, _ := credentials["password"].(string)
+	if username == "" || password == "" {
+		return nil, ErrNoCredentials
+	}
+
+	auth.RLock()
+	var hash, ok = auth.hashes[username]
+	auth.RUnlock()
+
+	// Unknown usernames take as long to reject as wrong passwords
+	if !ok {
+		hash = dummyPasswordHash()
+	}
+	if bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil || !ok {
+		return nil, ErrBadCredentials
+	}
+	return loadUser(auth.loader, username)
+}
+
+var dummyHash []byte
+var dummyHashOnce sync.Once
+
+func dummyPasswordHash() []byte {
+	dummyHashOnce.Do(func() {
+		hash, err := HashPassword("no such user")
+		if err != nil {
+			panic(err)
+		}
+		dummyHash = []byte(hash)
+	})
+	return dummyHash
+}
+
+func (auth *PasswordAuthenticator) save() error {
+	if auth.path == "" {
+		return nil
+	}
+
+	var usernames = make([]string, 0, len(auth.hashes))
+	for username := range auth.hashes {
+		usernames = append(usernames, username)
+	}
+	sort.Strings(usernames)
+
+	var lines []string
+	for _, username := range usernames {
+		lines = append(lines, username + ":" + string(auth.hashes[username]) + "\n")
+	}
+
+	var tmp = auth.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(strings.Join(lines, "")), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, auth.path)
+}
+
+
+// ------------------------------------------
+// API keys
+// ------------------------------------------
+
+// APIKeyAuthenticator checks a "key" against a fixed set of keys, each
+// belonging to a user id. Only hashes of the keys are kept.
+type APIKeyAuthenticator struct {
+	keys map[string]string
+	loader UserLoader
+	sync.RWMutex
+}
+
+func NewAPIKeyAuthenticator(loader UserLoader) *APIKeyAuthenticator {
+	return &APIKeyAuthenticator{
+		keys: make(map[string]string),
+		loader: loaderOrDefault(loader),
+	}
+}
+
+func (auth *APIKeyAuthenticator) AddKey(key string, userID string) {
+	auth.Lock()
+	auth.keys[hashKey(key)] = userID
+	auth.Unlock()
+}
+
+func (auth *APIKeyAuthenticator) RemoveKey(key string) {
+	auth.Lock()
+	delete(auth.keys, hashKey(key))
+	auth.Unlock()
+}
+
+func (auth *APIKeyAuthenticator) Authenticate(credentials APIData) (User, error) {
+	key, _ := credentials["key"].(string)
+	if key == "" {
+		return nil, ErrNoCredentials
+	}
+
+	auth.RLock()
+	var userID, ok = auth.keys[hashKey(key)]
+	auth.RUnlock()
+
+	if !ok {
+		return nil, ErrBadCredentials
+	}
+	return loadUser(auth.loader, userID)
+}
+
+func hashKey(key string) string {
+	var sum = sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+
+// ------------------------------------------
+// Signed tokens
+// ------------------------------------------
+
+// TokenAuthenticator checks a "token" issued by Issue: the user id and
+// an expiry time, signed with HMAC-SHA256.
+type TokenAuthenticator struct {
+	secret []byte
+	loader UserLoader
+}
+
+// NewTokenAuthenticator panics without a secret, since anyone could
+// sign tokens then.
+func NewTokenAuthenticator(secret []byte, loader UserLoader) *TokenAuthenticator {
+	if len(secret) == 0 {
+		panic("TokenAuthenticator needs a secret")
+	}
+	return &TokenAuthenticator{
+		secret: secret,
+		loader: loaderOrDefault(loader),
+	}
+}
+
+// Issue returns a token for userID valid for ttl.
+func (auth *TokenAuthenticator) Issue(userID string, ttl time.Duration) string {
+	var payload = base64.URLEncoding.EncodeToString([]byte(userID)) +
+		"." + strconv.FormatInt(time.Now().Add(ttl).Unix(), 10)
+	return payload + "." + auth.sign(payload)
+}
+
+func (auth *TokenAuthenticator) Authenticate(credentials APIData) (User, error) {
+	token, _ := credentials["token"].(string)
+	if token == "" {
+		return nil, ErrNoCredentials
+	}
+
+	var parts = strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrBadCredentials
+	}
+
+	var payload = parts[0] + "." + parts[1]
+	if subtle.ConstantTimeCompare([]byte(parts[2]), []byte(auth.sign(payload))) != 1 {
+		return nil, ErrBadCredentials
+	}
+
+	expiry, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || time.Now().Unix() > expiry {
+		return nil, ErrBadCredentials
+	}
+
+	userID, err := base64.URLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrBadCredentials
+	}
+	return loadUser(auth.loader, string(userID))
+}
+
+func (auth *TokenAuthenticator) sign(payload string) string {
+	var mac = hmac.New(sha256.New, auth.secret)
+	mac.Write([]byte(payload))
+	return base64.URLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,210 @@
+package goservice
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPasswordAuthenticator(t *testing.T) {
+	dir, err := ioutil.TempDir("", "passwords")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var path = filepath.Join(dir, "htpasswd")
+
+	auth, err := NewPasswordFileAuthenticator(path, nil)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if err := auth.AddUser("alice", "secret"); err != nil {
+		t.Fatalf("AddUser failed: %v", err)
+	}
+	if err := auth.AddUser("bad:name", "secret"); err == nil {
+		t.Errorf("Invalid username accepted")
+	}
+
+	// Reload from the file
+	auth, err = NewPasswordFileAuthenticator(path, nil)
+	if err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+
+	if user, err := auth.Authenticate(APIData{"username": "alice", "password": "secret"}); err != nil || user.ID() != "alice" {
+		t.Errorf("Login failed: %v, %v", user, err)
+	}
+
+	var cases = []struct {
+		credentials APIData
+		err error
+	}{
+		{APIData{"username": "alice", "password": "wrong"}, ErrBadCredentials},
+		{APIData{"username": "bob", "password": "secret"}, ErrBadCredentials},
+		{APIData{"username": "alice"}, ErrNoCredentials},
+		{APIData{"key": "abc"}, ErrNoCredentials},
+	}
+	for _, c := range cases {
+		if _, err := auth.Authenticate(c.credentials); err != c.err {
+			t.Errorf("%v: got %v, expected %v", c.credentials, err, c.err)
+		}
+	}
+
+	auth.RemoveUser("alice")
+	if _, err := auth.Authenticate(APIData{"username": "alice", "password": "secret"}); err != ErrBadCredentials {
+		t.Errorf("Removed user logged in: %v", err)
+	}
+}
+
+// Unknown usernames mustn't be told apart from wrong passwords by how
+// quickly they fail.
+func TestPasswordAuthenticatorUnknownUserTiming(t *testing.T) {
+	var auth = NewPasswordAuthenticator(nil)
+	auth.AddUser("alice", "secret")
+
+	var timeLogin = func(username string) time.Duration {
+		var start = time.Now()
+		auth.Authenticate(APIData{"username": username, "password": "wrong"})
+		return time.Since(start)
+	}
+
+	timeLogin("nobody")
+	var known, unknown = timeLogin("alice"), timeLogin("nobody")
+	if unknown < known / 4 {
+		t.Errorf("Unknown user rejected in %v, wrong password in %v", unknown, known)
+	}
+}
+
+func TestAPIKeyAuthenticator(t *testing.T) {
+	var auth = NewAPIKeyAuthenticator(nil)
+	auth.AddKey("abc", "robot")
+
+	if user, err := auth.Authenticate(APIData{"key": "abc"}); err != nil || user.ID() != "robot" {
+		t.Errorf("Login failed: %v, %v", user, err)
+	}
+	if _, err := auth.Authenticate(APIData{"key": "xyz"}); err != ErrBadCredentials {
+		t.Errorf("Wrong key: %v", err)
+	}
+
+	auth.RemoveKey("abc")
+	if _, err := auth.Authenticate(APIData{"key": "abc"}); err != ErrBadCredentials {
+		t.Errorf("Removed key: %v", err)
+	}
+}
+
+func TestTokenAuthenticator(t *testing.T) {
+	var auth = NewTokenAuthenticator([]byte("secret"), nil)
+
+	var token = auth.Issue("alice", time.Minute)
+	if user, err := auth.Authenticate(APIData{"token": token}); err != nil || user.ID() != "alice" {
+		t.Errorf("Login failed: %v, %v", user, err)
+	}
+
+	var other = NewTokenAuthenticator([]byte("other"), nil)
+	for _, bad := range []string{
+		token + "x",
+		other.Issue("alice", time.Minute),
+		auth.Issue("alice", -time.Minute),
+		"not a token",
+	} {
+		if _, err := auth.Authenticate(APIData{"token": bad}); err != ErrBadCredentials {
+			t.Errorf("Token %q: %v", bad, err)
+		}
+	}
+}
+
+func TestTokenAuthenticatorNeedsSecret(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Empty secret accepted")
+		}
+	}()
+	NewTokenAuthenticator(nil, nil)
+}
+
+func TestAuthService(t *testing.T) {
+	var passwords = NewPasswordAuthenticator(nil)
+	passwords.AddUser("alice", "secret")
+	var keys = NewAPIKeyAuthenticator(nil)
+	keys.AddKey("abc", "robot")
+
+	var api = NewServiceCollection()
+	api.AddService(NewAuthService(passwords, keys))
+	var harness = NewHarness(api, nil)
+	session, _ := harness.Connect()
+
+	var login = func(credentials APIData) APIData {
+		return harness.Call(session, "auth", "login", credentials)
+	}
+
+	if response := login(APIData{"username": "alice", "password": "wrong"}); response["success"] != false {
+		t.Errorf("Wrong password: %v", response)
+	}
+	if response := login(APIData{}); response["success"] != false {
+		t.Errorf("No credentials: %v", response)
+	}
+
+	if response := login(APIData{"key": "abc"}); response["success"] != true || session.User().ID() != "robot" {
+		t.Errorf("Key login: %v", response)
+	}
+	if response := login(APIData{"username": "alice", "password": "secret"}); response["success"] != true || session.User().ID() != "alice" {
+		t.Errorf("Password login: %v", response)
+	}
+
+	var whoami = harness.Call(session, "auth", "whoami", nil)
+	if data, _ := whoami["data"].(APIData); data["user"] != "alice" {
+		t.Errorf("Wrong whoami: %v", whoami)
+	}
+
+	harness.Call(session, "auth", "logout", nil)
+	if session.User() != nil {
+		t.Errorf("Still logged in")
+	}
+}
+
+// A token planted on the client before login (session fixation) must
+// not carry the logged-in session.
+func TestAuthLoginRenewsSessionToken(t *testing.T) {
+	var passwords = NewPasswordAuthenticator(nil)
+	passwords.AddUser("alice", "secret")
+	var api = NewServiceCollection()
+	api.AddService(NewAuthService(passwords))
+
+	var tracker = NewSessionTracker(SessionTrackerOptions{Secret: []byte("secret")})
+	var harness = NewHarness(api, nil)
+	var endpoint = NewHttpRpcEndpoint("", harness.Server, &HttpRpcEndpointOptions{
+		Resolver: tracker.Resolve,
+		Releaser: tracker.Release,
+		APIUri: "/",
+	})
+
+	var call = func(path string, body string, token string) (string, APIData) {
+		var req = jsonRequest(path, body)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer " + token)
+		}
+		recorder, response := serveHttp(endpoint.(http.Handler), req)
+		return recorder.Header().Get("X-Session-Token"), response
+	}
+
+	var planted, _ = call("/auth/whoami", "{}", "")
+	var renewed, response = call("/auth/login", `{"username": "alice", "password": "secret"}`, planted)
+	if response["success"] != true || renewed == "" || renewed == planted {
+		t.Fatalf("No new token on login: %q, %v", renewed, response)
+	}
+
+	if _, response = call("/auth/whoami", "{}", planted); response != nil {
+		t.Errorf("Planted token still works: %v", response)
+	}
+	if _, response = call("/auth/whoami", "{}", renewed); response["data"].(APIData)["user"] != "alice" {
+		t.Errorf("New token doesn't work: %v", response)
+	}
+
+	var loggedOut, _ = call("/auth/logout", "{}", renewed)
+	if loggedOut == "" || loggedOut == renewed {
+		t.Errorf("No new token on logout: %q", loggedOut)
+	}
+}
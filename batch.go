@@ -0,0 +1,171 @@
+package goservice
+
+import (
+	"fmt"
+	"sync"
+)
+
+var batchArgSpec = []APIArg {
+	APIArg{Name: "batch", ArgType: RawArg},
+	APIArg{Name: "parallel", ArgType: RawArg, Default: false},
+	APIArg{Name: "stopOnError", ArgType: RawArg, Default: false},
+}
+
+// MaxBatchSize is the most calls a batch may hold (zero for no
+// limit), and BatchWorkers how many of a parallel batch's calls run
+// at once.
+var (
+	MaxBatchSize = 100
+	BatchWorkers = 8
+)
+
+// IsBatch reports whether a request envelope carries a batch of calls
+// rather than a single one.
+func IsBatch(request APIData) bool {
+	_, ok := request["batch"]
+	return ok
+}
+
+// HandleBatch runs each {service, method, data, id} request in
+// request["batch"] through api.HandleRequest and returns the responses
+// in the same order, under "batch". With "parallel" the calls run
+// concurrently; with "stopOnError" calls after the first failure are
+// skipped, which needs them run in order, so the two can't be
+// combined. Batches over MaxBatchSize are rejected.
+func HandleBatch(api API, request APIData, session Session, context ServerContext) APIData {
+	ok, resolutionErrors, args := Parse(batchArgSpec, request)
+	if !ok {
+		return ErrorResponse(ListToStringSlice(resolutionErrors))
+	}
+
+	calls, ok := args["batch"].([]interface{})
+	if !ok {
+		return ErrorResponse([]string{"Batch must be a list of requests"})
+	}
+	if MaxBatchSize > 0 && len(calls) > MaxBatchSize {
+		return ErrorResponse([]string{fmt.Sprintf("Batch too large (at most %d calls)", MaxBatchSize)})
+	}
+
+	var parallel, stopOnError = isTrue(args["parallel"]), isTrue(args["stopOnError"])
+	if parallel && stopOnError {
+		return ErrorResponse([]string{"stopOnError cannot be used with parallel"})
+	}
+
+	var responses = make([]APIData, len(calls))
+
+	var lock sync.Mutex
+	var failed = false
+
+	var run = func(i int) {
+		// A panicking call fails on its own rather than taking the
+		// server down with it, which matters most in parallel, where
+		// nothing further up would recover.
+		defer func() {
+			if err := recover(); err != nil {
+				if context != nil {
+					context.Log("Panic in batch call: %v", err)
+				}
+				responses[i] = batchItemResponse(calls[i],
+					ErrorResponse([]string{"Internal error"}))
+				lock.Lock()
+				failed = true
+				lock.Unlock()
+			}
+		}()
+
+		if stopOnError {
+			lock.Lock()
+			var skip = failed
+			lock.Unlock()
+			if skip {
+				responses[i] = batchItemResponse(calls[i],
+					ErrorResponse([]string{"Skipped after earlier failure"}))
+				return
+			}
+		}
+
+		var response = batchCall(api, calls[i], session, context)
+		responses[i] = response
+
+		if success, _ := response["success"].(bool); !success {
+			lock.Lock()
+			failed = true
+			lock.Unlock()
+		}
+	}
+
+	if parallel {
+		var workers = BatchWorkers
+		if workers < 1 {
+			workers = 1
+		}
+		if workers > len(calls) {
+			workers = len(calls)
+		}
+
+		var next = make(chan int)
+		var wg sync.WaitGroup
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := range next {
+					run(i)
+				}
+			}()
+		}
+		for i := range calls {
+			next <- i
+		}
+		close(next)
+		wg.Wait()
+	} else {
+		for i := range calls {
+			run(i)
+		}
+	}
+
+	var response = make(APIData)
+	response["success"] = !failed
+	response["batch"] = responses
+	return response
+}
+
+func batchCall(api API, call interface{}, session Session, context ServerContext) APIData {
+	request, ok := call.(APIData)
+	if !ok {
+		return ErrorResponse([]string{"Batch entries must be requests"})
+	}
+
+	if IsBatch(request) {
+		return batchItemResponse(request, ErrorResponse([]string{"Batches cannot be nested"}))
+	}
+
+	return batchItemResponse(request, api.HandleRequest(request, session, context))
+}
+
+func batchItemResponse(call interface{}, response APIData) APIData {
+	if request, ok := call.(APIData); ok {
+		if id, ok := request["id"]; ok {
+			response["id"] = id
+		}
+	}
+	return response
+}
+
+// isTrue accepts the ways a flag arrives over each transport: a real
+// bool from JSON or msgpack, or a string from a form.
+func isTrue(val interface{}) bool {
+	switch val.(type) {
+	case bool:
+		return val.(bool)
+	case string:
+		var s = val.(string)
+		return s == "true" || s == "1" || s == "yes"
+	case int:
+		return val.(int) != 0
+	case float64:
+		return val.(float64) != 0
+	}
+	return false
+}
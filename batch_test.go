@@ -0,0 +1,126 @@
+package goservice
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func runBatch(t *testing.T, request APIData) []APIData {
+	var harness = NewHarness(testAPI(), nil)
+	session, _ := harness.Connect()
+
+	var response = HandleBatch(harness.Server.API(), request, session, harness.Server)
+	responses, ok := response["batch"].([]APIData)
+	if !ok {
+		t.Fatalf("No batch in response: %v", response)
+	}
+	return responses
+}
+
+func TestHandleRequestNonObjectData(t *testing.T) {
+	var harness = NewHarness(testAPI(), nil)
+	session, _ := harness.Connect()
+
+	var response = harness.Endpoint.HandleRequest(APIData{
+		"service": "test",
+		"method": "echo",
+		"data": "text=hi",
+	}, session)
+	expectReason(t, response, "call error")
+}
+
+func TestBatch(t *testing.T) {
+	for _, parallel := range []bool{false, true} {
+		var responses = runBatch(t, APIData{
+			"parallel": parallel,
+			"batch": []interface{}{
+				APIData{"id": 1, "service": "test", "method": "echo", "data": APIData{"text": "a"}},
+				APIData{"id": 2, "service": "test", "method": "echo", "data": "text=b"},
+				APIData{"id": 3, "service": "test", "method": "panic", "data": APIData{}},
+				"not a request",
+			},
+		})
+
+		if len(responses) != 4 {
+			t.Fatalf("Expected 4 responses, got %v", responses)
+		}
+		if success, _ := responses[0]["success"].(bool); !success || responses[0]["id"] != 1 {
+			t.Errorf("Wrong first response: %v", responses[0])
+		}
+		for _, response := range responses[1:] {
+			expectReason(t, response, "call error")
+		}
+		if responses[2]["id"] != 3 {
+			t.Errorf("Panicking call lost its id: %v", responses[2])
+		}
+	}
+}
+
+func TestBatchStopOnError(t *testing.T) {
+	var responses = runBatch(t, APIData{
+		"stopOnError": true,
+		"batch": []interface{}{
+			APIData{"service": "test", "method": "fail", "data": APIData{}},
+			APIData{"service": "test", "method": "echo", "data": APIData{"text": "a"}},
+		},
+	})
+
+	expectReason(t, responses[0], "failure")
+	expectReason(t, responses[1], "call error")
+
+	var harness = NewHarness(testAPI(), nil)
+	var response = HandleBatch(harness.Server.API(), APIData{
+		"parallel": true,
+		"stopOnError": true,
+		"batch": []interface{}{},
+	}, nil, harness.Server)
+	expectReason(t, response, "call error")
+}
+
+func TestBatchLimits(t *testing.T) {
+	var oldSize, oldWorkers = MaxBatchSize, BatchWorkers
+	defer func() {
+		MaxBatchSize, BatchWorkers = oldSize, oldWorkers
+	}()
+	MaxBatchSize, BatchWorkers = 20, 3
+
+	var lock sync.Mutex
+	var running, most = 0, 0
+	var svc = NewService("slow")
+	svc.AddMethod("wait", nil, func(args APIData, session Session, context ServerContext) (bool, APIData) {
+		lock.Lock()
+		running++
+		if running > most {
+			most = running
+		}
+		lock.Unlock()
+
+		time.Sleep(5 * time.Millisecond)
+
+		lock.Lock()
+		running--
+		lock.Unlock()
+		return true, nil
+	})
+	var api = NewServiceCollection()
+	api.AddService(svc)
+
+	var batch = func(size int) APIData {
+		var calls []interface{}
+		for i := 0; i < size; i++ {
+			calls = append(calls, APIData{"service": "slow", "method": "wait", "data": APIData{}})
+		}
+		return APIData{"parallel": true, "batch": calls}
+	}
+
+	expectReason(t, HandleBatch(api, batch(21), nil, nil), "call error")
+
+	var response = HandleBatch(api, batch(20), nil, nil)
+	if success, _ := response["success"].(bool); !success {
+		t.Fatalf("Batch failed: %v", response)
+	}
+	if most > 3 {
+		t.Errorf("%d calls ran at once", most)
+	}
+}
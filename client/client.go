@@ -0,0 +1,82 @@
+// Package client provides Go clients for the go-service transports:
+// HttpRpcEndpoint, WebsocketEndpoint and TelnetEndpoint.
+package client
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	goservice "github.com/brendonh/go-service"
+)
+
+// Client is implemented by each transport.
+type Client interface {
+	Call(service string, method string, data goservice.APIData) (goservice.APIData, error)
+	Close() error
+}
+
+// PushHandler receives messages the server sent through Session.Send.
+type PushHandler func([]byte)
+
+// TopicHandler receives messages published to a topic the session
+// subscribed to.
+type TopicHandler func(topic string, data goservice.APIData)
+
+// Options are shared by all transports. Reconnect and OnPush only
+// apply to transports that hold a connection open. Username and
+// Password answer a telnet endpoint's login prompt.
+type Options struct {
+	Timeout time.Duration
+	Reconnect bool
+	ReconnectDelay time.Duration
+	OnPush PushHandler
+	OnTopic TopicHandler
+	Username string
+	Password string
+}
+
+var defaultOptions = &Options{
+	Timeout: 30 * time.Second,
+	Reconnect: true,
+	ReconnectDelay: time.Second,
+}
+
+var (
+	ErrTimeout = errors.New("call timed out")
+	ErrClosed = errors.New("client closed")
+	ErrDisconnected = errors.New("not connected")
+)
+
+// CallError is returned when the server answered but the call did
+// not succeed. Reason is "call error" when the request itself was
+// rejected, or "failure" when the handler reported failure.
+type CallError struct {
+	Reason string
+	Errors interface{}
+}
+
+func (err *CallError) Error() string {
+	return fmt.Sprintf("%s: %v", err.Reason, err.Errors)
+}
+
+func resolveOptions(options *Options) *Options {
+	if options == nil {
+		return defaultOptions
+	}
+	return options
+}
+
+// responseResult unpacks the envelope built by goservice.Response.
+func responseResult(response goservice.APIData) (goservice.APIData, error) {
+	if success, _ := response["success"].(bool); success {
+		data, _ := response["data"].(goservice.APIData)
+		return data, nil
+	}
+
+	reason, _ := response["reason"].(string)
+	return nil, &CallError{
+		Reason: reason,
+		Errors: response["errors"],
+	}
+}
@@ -0,0 +1,43 @@
+package client
+
+import (
+	"net"
+	"testing"
+
+	goservice "github.com/brendonh/go-service"
+)
+
+// testServer serves a "test" service with echo, fail and count
+// methods, the latter counting calls in the session, plus topics.
+func testServer() *goservice.Server {
+	var svc = goservice.NewService("test")
+	svc.AddMethod("echo", []goservice.APIArg{
+		goservice.APIArg{Name: "text", ArgType: goservice.StringArg},
+	}, func(args goservice.APIData, session goservice.Session, context goservice.ServerContext) (bool, goservice.APIData) {
+		return true, goservice.APIData{"text": args["text"]}
+	})
+	svc.AddMethod("fail", nil, func(args goservice.APIData, session goservice.Session, context goservice.ServerContext) (bool, goservice.APIData) {
+		return false, goservice.APIData{"why": "asked to"}
+	})
+	svc.AddMethod("count", nil, func(args goservice.APIData, session goservice.Session, context goservice.ServerContext) (bool, goservice.APIData) {
+		count, _ := session.Get("count")
+		n, _ := count.(int)
+		session.Set("count", n + 1)
+		return true, goservice.APIData{"count": n + 1}
+	})
+
+	var api = goservice.NewServiceCollection()
+	api.AddService(svc)
+	api.AddService(goservice.NewTopicService())
+	return goservice.NewServer(api, goservice.BasicSessionCreator, nil)
+}
+
+// freeAddress finds a local port for an endpoint to listen on.
+func freeAddress(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().String()
+}
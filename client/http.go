@@ -0,0 +1,121 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+
+	goservice "github.com/brendonh/go-service"
+)
+
+// HttpClient calls an HttpRpcEndpoint. Arguments are sent as a form
+// unless JSON is set, which is required for nested arguments.
+type HttpClient struct {
+	BaseURL string
+	JSON bool
+	http *http.Client
+}
+
+func NewHttpClient(baseURL string, options *Options) *HttpClient {
+	options = resolveOptions(options)
+
+	if !strings.HasSuffix(baseURL, "/") {
+		baseURL += "/"
+	}
+
+	jar, _ := cookiejar.New(nil)
+
+	return &HttpClient{
+		BaseURL: baseURL,
+		http: &http.Client{
+			Timeout: options.Timeout,
+			Jar: jar,
+		},
+	}
+}
+
+func (client *HttpClient) Call(service string, method string, data goservice.APIData) (goservice.APIData, error) {
+	var url = client.BaseURL + service + "/" + method
+
+	var req *http.Request
+	var err error
+	if client.JSON {
+		req, err = client.jsonRequest(url, data)
+	} else {
+		req, err = client.formRequest(url, data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Lets the endpoint's SessionTracker accept our cookie on form calls
+	req.Header.Set("X-Requested-With", "goservice")
+
+	resp, err := client.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		var body = new(bytes.Buffer)
+		body.ReadFrom(resp.Body)
+		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, body.String())
+	}
+
+	var response interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, err
+	}
+
+	envelope, ok := goservice.ToAPIData(response).(goservice.APIData)
+	if !ok {
+		return nil, fmt.Errorf("Invalid response: %v", response)
+	}
+
+	return responseResult(envelope)
+}
+
+func (client *HttpClient) Close() error {
+	return nil
+}
+
+func (client *HttpClient) jsonRequest(url string, data goservice.APIData) (*http.Request, error) {
+	// The endpoint wants an object, even an empty one
+	if data == nil {
+		data = goservice.APIData{}
+	}
+
+	body, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return req, nil
+}
+
+func (client *HttpClient) formRequest(target string, data goservice.APIData) (*http.Request, error) {
+	var form = make(url.Values)
+	for k, v := range data {
+		switch v.(type) {
+		case goservice.APIData, map[string]interface{}, []interface{}:
+			return nil, fmt.Errorf("Argument %s is nested; use JSON", k)
+		}
+		form.Set(k, fmt.Sprint(v))
+	}
+
+	req, err := http.NewRequest("POST", target, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req, nil
+}
@@ -0,0 +1,61 @@
+package client
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	goservice "github.com/brendonh/go-service"
+)
+
+func startHttp(t *testing.T) *httptest.Server {
+	var tracker = goservice.NewSessionTracker(goservice.SessionTrackerOptions{Secret: []byte("secret")})
+	var endpoint = goservice.NewHttpRpcEndpoint("", testServer(), &goservice.HttpRpcEndpointOptions{
+		Resolver: tracker.Resolve,
+		Releaser: tracker.Release,
+		APIUri: "/api/",
+	}).(*goservice.HttpRpcEndpoint)
+	return httptest.NewServer(endpoint.Mux)
+}
+
+func TestHttpClientCall(t *testing.T) {
+	var server = startHttp(t)
+	defer server.Close()
+
+	for _, useJSON := range []bool{false, true} {
+		var client = NewHttpClient(server.URL + "/api", nil)
+		client.JSON = useJSON
+
+		if data, err := client.Call("test", "echo", goservice.APIData{"text": "hi"}); err != nil || data["text"] != "hi" {
+			t.Errorf("JSON %v: echo got %v, %v", useJSON, data, err)
+		}
+
+		// The session cookie carries the count between calls
+		for i := 1; i <= 2; i++ {
+			data, err := client.Call("test", "count", nil)
+			if err != nil || data["count"] != float64(i) {
+				t.Errorf("JSON %v: count got %v, %v", useJSON, data, err)
+			}
+		}
+
+		_, err := client.Call("test", "fail", nil)
+		if callErr, ok := err.(*CallError); !ok || callErr.Reason != "failure" {
+			t.Errorf("JSON %v: fail got %v", useJSON, err)
+		}
+
+		_, err = client.Call("test", "nope", nil)
+		if callErr, ok := err.(*CallError); !ok || callErr.Reason != "call error" {
+			t.Errorf("JSON %v: unknown method got %v", useJSON, err)
+		}
+	}
+}
+
+func TestHttpClientNestedForm(t *testing.T) {
+	var server = startHttp(t)
+	defer server.Close()
+
+	var client = NewHttpClient(server.URL + "/api", nil)
+	var nested = goservice.APIData{"text": goservice.APIData{"a": 1}}
+	if _, err := client.Call("test", "echo", nested); err == nil {
+		t.Errorf("Nested argument sent as a form")
+	}
+}
@@ -0,0 +1,314 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	goservice "github.com/brendonh/go-service"
+)
+
+// TelnetClient drives a TelnetEndpoint in script mode, sending each
+// argument as a quoted key=value word and reading back one JSON line
+// per call. Messages pushed to the session come in between, and are
+// handed to Options.OnTopic (published messages) or Options.OnPush
+// (anything else) from the goroutine reading the connection.
+type TelnetClient struct {
+	Address string
+
+	options *Options
+	conn net.Conn
+	reader *bufio.Reader
+	replies chan string
+	sync.Mutex
+}
+
+func NewTelnetClient(address string, options *Options) (*TelnetClient, error) {
+	var client = &TelnetClient{
+		Address: address,
+		options: resolveOptions(options),
+	}
+
+	client.Lock()
+	defer client.Unlock()
+
+	if err := client.connect(); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+func (client *TelnetClient) Call(service string, method string, data goservice.APIData) (goservice.APIData, error) {
+	client.Lock()
+	defer client.Unlock()
+
+	if client.conn == nil {
+		if !client.options.Reconnect {
+			return nil, ErrDisconnected
+		}
+		if err := client.connect(); err != nil {
+			return nil, err
+		}
+	}
+
+	var names = make([]string, 0, len(data))
+	for name := range data {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var tokens = []string{quoteWord(service), quoteWord(method)}
+	for _, name := range names {
+		str, err := argString(data[name])
+		if err != nil {
+			return nil, fmt.Errorf("Argument %s cannot be sent over telnet: %v", name, err)
+		}
+		if strings.ContainsAny(str, "\r\n") {
+			return nil, fmt.Errorf("Argument %s cannot be sent over telnet: %q", name, str)
+		}
+		tokens = append(tokens, name + "=" + quoteWord(str))
+	}
+
+	output, err := client.command(strings.Join(tokens, " "))
+	if err != nil {
+		return nil, err
+	}
+
+	var response interface{}
+	if err := json.Unmarshal([]byte(output), &response); err != nil {
+		return nil, err
+	}
+
+	envelope, _ := goservice.ToAPIData(response).(goservice.APIData)
+	return responseResult(envelope)
+}
+
+func (client *TelnetClient) Close() error {
+	client.Lock()
+	defer client.Unlock()
+
+	if client.conn == nil {
+		return nil
+	}
+	client.conn.Write([]byte("quit\n"))
+	var err = client.conn.Close()
+	client.conn = nil
+	return err
+}
+
+func (client *TelnetClient) connect() error {
+	conn, err := net.DialTimeout("tcp", client.Address, client.options.Timeout)
+	if err != nil {
+		return err
+	}
+
+	client.conn = conn
+	client.reader = bufio.NewReader(conn)
+
+	if client.options.Username != "" {
+		if err := client.login(); err != nil {
+			client.drop()
+			return err
+		}
+	}
+
+	// The banner and prompt, whatever they are, are skipped while
+	// waiting for script mode's first JSON line
+	if err := client.startScript(); err != nil {
+		client.drop()
+		return err
+	}
+
+	client.replies = make(chan string, 1)
+	go client.receive(conn, client.reader, client.replies)
+	return nil
+}
+
+// startScript switches the console to script mode, so responses are
+// single JSON lines that pushes can't be mistaken for.
+func (client *TelnetClient) startScript() error {
+	if client.options.Timeout > 0 {
+		client.conn.SetDeadline(time.Now().Add(client.options.Timeout))
+	}
+
+	if _, err := client.conn.Write([]byte("script\n")); err != nil {
+		return err
+	}
+
+	for {
+		line, err := client.reader.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		if _, ok := scriptLine(line)["status"]; ok {
+			break
+		}
+	}
+
+	// From here on only the reader goroutine reads, and waits as long
+	// as it takes; calls time out on their own.
+	client.conn.SetDeadline(time.Time{})
+	return nil
+}
+
+func (client *TelnetClient) login() error {
+	if client.options.Timeout > 0 {
+		client.conn.SetDeadline(time.Now().Add(client.options.Timeout))
+	}
+
+	if _, err := client.readUntil("Username: "); err != nil {
+		return err
+	}
+	client.conn.Write([]byte(client.options.Username + "\n"))
+
+	if _, err := client.readUntil("Password: "); err != nil {
+		return err
+	}
+	client.conn.Write([]byte(client.options.Password + "\n"))
+
+	line, err := client.reader.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "Logged in") {
+		return &CallError{Reason: "unauthorized", Errors: []string{strings.TrimSpace(line)}}
+	}
+	return nil
+}
+
+func (client *TelnetClient) command(line string) (string, error) {
+	var timeout <-chan time.Time
+	if client.options.Timeout > 0 {
+		client.conn.SetWriteDeadline(time.Now().Add(client.options.Timeout))
+		timeout = time.After(client.options.Timeout)
+	}
+
+	if _, err := client.conn.Write([]byte(line + "\n")); err != nil {
+		client.drop()
+		return "", err
+	}
+
+	// A reply that turns up after a timeout would answer the wrong
+	// call, so the connection goes either way.
+	select {
+	case output, ok := <-client.replies:
+		if !ok {
+			client.drop()
+			return "", ErrDisconnected
+		}
+		return output, nil
+	case <-timeout:
+		client.drop()
+		return "", ErrTimeout
+	}
+}
+
+// receive reads the connection until it closes, passing responses
+// to the waiting call and pushes to the handlers.
+func (client *TelnetClient) receive(conn net.Conn, reader *bufio.Reader, replies chan string) {
+	defer close(replies)
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		var message = scriptLine(line)
+		if push, ok := message["push"]; ok {
+			client.pushed(push)
+			continue
+		}
+
+		// Watch results also have a status, but this client never
+		// starts a watch.
+		if _, ok := message["status"]; ok && message["watch"] == nil {
+			select {
+			case replies <- line:
+			default:
+			}
+		}
+	}
+}
+
+// pushed dispatches a {"push": ...} line's message: a published
+// message to OnTopic, anything else as sent to OnPush. The server
+// wraps messages that weren't JSON as strings.
+func (client *TelnetClient) pushed(push json.RawMessage) {
+	var published struct {
+		Topic *string `json:"topic"`
+		Data interface{} `json:"data"`
+	}
+	if json.Unmarshal(push, &published) == nil && published.Topic != nil {
+		if client.options.OnTopic != nil {
+			data, _ := goservice.ToAPIData(published.Data).(goservice.APIData)
+			client.options.OnTopic(*published.Topic, data)
+		}
+		return
+	}
+
+	if client.options.OnPush == nil {
+		return
+	}
+	var str string
+	if json.Unmarshal(push, &str) == nil {
+		client.options.OnPush([]byte(str))
+		return
+	}
+	client.options.OnPush([]byte(push))
+}
+
+// scriptLine decodes the top level of a script mode line, or returns
+// nil if it isn't one.
+func scriptLine(line string) map[string]json.RawMessage {
+	var message map[string]json.RawMessage
+	if json.Unmarshal([]byte(line), &message) != nil {
+		return nil
+	}
+	return message
+}
+
+func (client *TelnetClient) readUntil(marker string) (string, error) {
+	var buf = new(bytes.Buffer)
+	var prompt = []byte(marker)
+	for !bytes.HasSuffix(buf.Bytes(), prompt) {
+		b, err := client.reader.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		buf.WriteByte(b)
+	}
+	return string(buf.Bytes()[:buf.Len()-len(prompt)]), nil
+}
+
+// argString renders a value the way the endpoint parses it back:
+// strings as-is, maps and lists as inline JSON.
+func argString(val interface{}) (string, error) {
+	switch val.(type) {
+	case string:
+		return val.(string), nil
+	case map[string]interface{}, goservice.APIData, []interface{}:
+		encoded, err := json.Marshal(val)
+		return string(encoded), err
+	}
+	return fmt.Sprint(val), nil
+}
+
+// quoteWord single-quotes a word for the endpoint's shell-like
+// tokenizer.
+func quoteWord(word string) string {
+	return "'" + strings.Replace(word, "'", `'\''`, -1) + "'"
+}
+
+func (client *TelnetClient) drop() {
+	client.conn.Close()
+	client.conn = nil
+	client.reader = nil
+	client.replies = nil
+}
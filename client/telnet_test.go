@@ -0,0 +1,116 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	goservice "github.com/brendonh/go-service"
+)
+
+// startTelnet starts a telnet endpoint, letting configure set it up
+// first.
+func startTelnet(t *testing.T, server *goservice.Server, configure func(*goservice.TelnetEndpoint)) *goservice.TelnetEndpoint {
+	var endpoint = goservice.NewTelnetEndpoint(freeAddress(t), server)
+	if configure != nil {
+		configure(endpoint)
+	}
+	if !endpoint.Start() {
+		t.Fatalf("Telnet endpoint didn't start")
+	}
+	return endpoint
+}
+
+func TestTelnetClientCall(t *testing.T) {
+	var server = testServer()
+	var endpoint = startTelnet(t, server, func(endpoint *goservice.TelnetEndpoint) {
+		endpoint.Prompt = "custom$ "
+	})
+	defer endpoint.Stop()
+
+	var connected = make(chan error, 1)
+	var client *TelnetClient
+	go func() {
+		var err error
+		client, err = NewTelnetClient(endpoint.Address, &Options{Timeout: 2 * time.Second})
+		connected <- err
+	}()
+
+	select {
+	case err := <-connected:
+		if err != nil {
+			t.Fatalf("Connect failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Connect hung with a custom prompt")
+	}
+	defer client.Close()
+
+	data, err := client.Call("test", "echo", goservice.APIData{"text": "it's here"})
+	if err != nil || data["text"] != "it's here" {
+		t.Errorf("Wrong response: %v, %v", data, err)
+	}
+
+	_, err = client.Call("test", "fail", nil)
+	if callErr, ok := err.(*CallError); !ok || callErr.Reason != "failure" {
+		t.Errorf("Wrong error: %v", err)
+	}
+}
+
+func TestTelnetClientLogin(t *testing.T) {
+	var passwords = goservice.NewPasswordAuthenticator(nil)
+	passwords.AddUser("alice", "secret")
+
+	var server = testServer()
+	var endpoint = startTelnet(t, server, func(endpoint *goservice.TelnetEndpoint) {
+		endpoint.Authenticators = []goservice.Authenticator{passwords}
+	})
+	defer endpoint.Stop()
+
+	var options = Options{Timeout: 2 * time.Second, Username: "alice", Password: "wrong"}
+	if _, err := NewTelnetClient(endpoint.Address, &options); err == nil {
+		t.Errorf("Wrong password accepted")
+	}
+
+	options.Password = "secret"
+	client, err := NewTelnetClient(endpoint.Address, &options)
+	if err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+	defer client.Close()
+
+	if data, err := client.Call("test", "echo", goservice.APIData{"text": "hi"}); err != nil || data["text"] != "hi" {
+		t.Errorf("Wrong response: %v, %v", data, err)
+	}
+}
+
+func TestTelnetClientTopic(t *testing.T) {
+	var server = testServer()
+	var endpoint = startTelnet(t, server, nil)
+	defer endpoint.Stop()
+
+	var published = make(chan goservice.APIData, 1)
+	client, err := NewTelnetClient(endpoint.Address, &Options{
+		Timeout: 2 * time.Second,
+		OnTopic: func(topic string, data goservice.APIData) {
+			published <- data
+		},
+	})
+	if err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer client.Close()
+
+	if _, err := client.Call("topics", "subscribe", goservice.APIData{"topic": "news"}); err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	server.Publish("news", goservice.APIData{"headline": "hi"})
+
+	select {
+	case data := <-published:
+		if data["headline"] != "hi" {
+			t.Errorf("Wrong data: %v", data)
+		}
+	case <-time.After(2 * time.Second):
+		t.Errorf("Nothing published")
+	}
+}
@@ -0,0 +1,285 @@
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	goservice "github.com/brendonh/go-service"
+	"github.com/ugorji/go-msgpack"
+	"code.google.com/p/go.net/websocket"
+)
+
+// WebsocketClient calls a WebsocketEndpoint using msgpack envelopes.
+// Replies are matched to calls by id, published messages go to
+// Options.OnTopic, and any other frame is handed to Options.OnPush.
+// Reconnects resume the same server session when the endpoint has
+// resumption (ResumeGrace) turned on.
+type WebsocketClient struct {
+	URL string
+	Origin string
+
+	options *Options
+	conn *websocket.Conn
+	resumeToken string
+	nextId int64
+	pending map[int64]chan goservice.APIData
+	closed bool
+	sync.Mutex
+}
+
+func NewWebsocketClient(endpointURL string, origin string, options *Options) (*WebsocketClient, error) {
+	var client = &WebsocketClient{
+		URL: endpointURL,
+		Origin: origin,
+		options: resolveOptions(options),
+		pending: make(map[int64]chan goservice.APIData),
+	}
+
+	if err := client.connect(); err != nil {
+		return nil, err
+	}
+
+	return client, nil
+}
+
+func (client *WebsocketClient) Call(service string, method string, data goservice.APIData) (goservice.APIData, error) {
+	client.Lock()
+	if client.closed {
+		client.Unlock()
+		return nil, ErrClosed
+	}
+	if client.conn == nil {
+		client.Unlock()
+		return nil, ErrDisconnected
+	}
+
+	client.nextId++
+	var id = client.nextId
+	var reply = make(chan goservice.APIData, 1)
+	client.pending[id] = reply
+	var conn = client.conn
+	client.Unlock()
+
+	var w = new(bytes.Buffer)
+	err := msgpack.NewEncoder(w).Encode(goservice.APIData{
+		"service": service,
+		"method": method,
+		"data": data,
+		"id": id,
+	})
+	if err == nil {
+		err = websocket.Message.Send(conn, w.Bytes())
+	}
+	if err != nil {
+		client.forget(id)
+		return nil, err
+	}
+
+	var timeout <-chan time.Time
+	if client.options.Timeout > 0 {
+		timeout = time.After(client.options.Timeout)
+	}
+
+	select {
+	case response, ok := <-reply:
+		if !ok {
+			return nil, ErrDisconnected
+		}
+		return responseResult(response)
+	case <-timeout:
+		client.forget(id)
+		return nil, ErrTimeout
+	}
+}
+
+func (client *WebsocketClient) Close() error {
+	client.Lock()
+	defer client.Unlock()
+
+	client.closed = true
+	if client.conn == nil {
+		return nil
+	}
+	return client.conn.Close()
+}
+
+// connect dials the endpoint, resuming the previous session if the
+// server announced a resume token for it.
+func (client *WebsocketClient) connect() error {
+	client.Lock()
+	var target = client.URL
+	if client.resumeToken != "" {
+		var separator = "?"
+		if strings.Contains(target, "?") {
+			separator = "&"
+		}
+		target += separator + "resume=" + url.QueryEscape(client.resumeToken)
+	}
+	client.Unlock()
+
+	conn, err := websocket.Dial(target, "", client.Origin)
+	if err != nil {
+		return err
+	}
+	conn.PayloadType = websocket.BinaryFrame
+
+	client.Lock()
+	client.conn = conn
+	client.Unlock()
+
+	go client.readLoop(conn)
+	return nil
+}
+
+func (client *WebsocketClient) readLoop(conn *websocket.Conn) {
+	for {
+		var msg []byte
+		if err := websocket.Message.Receive(conn, &msg); err != nil {
+			break
+		}
+		client.dispatch(msg)
+	}
+
+	client.disconnected(conn)
+}
+
+// dispatch handles one frame. Replies, published messages and
+// session announcements are recognised by their prefix byte, but a
+// message from Session.Send may happen to start with the same byte,
+// so any frame that doesn't decode as what its prefix says goes to
+// OnPush instead.
+func (client *WebsocketClient) dispatch(msg []byte) {
+	var handled = false
+	if len(msg) > 0 {
+		switch msg[0] {
+		case 'a':
+			handled = client.answered(msg[1:])
+		case 'p':
+			handled = client.published(msg[1:])
+		case 's':
+			handled = client.announced(msg[1:])
+		}
+	}
+
+	if !handled && client.options.OnPush != nil {
+		client.options.OnPush(msg)
+	}
+}
+
+func (client *WebsocketClient) answered(buf []byte) bool {
+	response, err := decodeMessage(buf)
+	if err != nil {
+		return false
+	}
+
+	id, ok := numericId(response["id"])
+	if !ok {
+		return false
+	}
+
+	client.Lock()
+	reply, ok := client.pending[id]
+	delete(client.pending, id)
+	client.Unlock()
+
+	if ok {
+		reply <- response
+	}
+	return true
+}
+
+func (client *WebsocketClient) disconnected(conn *websocket.Conn) {
+	client.Lock()
+	conn.Close()
+	client.conn = nil
+	for id, reply := range client.pending {
+		close(reply)
+		delete(client.pending, id)
+	}
+	var reconnect = client.options.Reconnect && !client.closed
+	client.Unlock()
+
+	for reconnect {
+		time.Sleep(client.options.ReconnectDelay)
+		if client.connect() == nil {
+			return
+		}
+
+		client.Lock()
+		reconnect = !client.closed
+		client.Unlock()
+	}
+}
+
+func (client *WebsocketClient) published(buf []byte) bool {
+	message, err := decodeMessage(buf)
+	if err != nil {
+		return false
+	}
+
+	topic, ok := message["topic"].(string)
+	if !ok {
+		return false
+	}
+
+	if client.options.OnTopic != nil {
+		data, _ := message["data"].(goservice.APIData)
+		client.options.OnTopic(topic, data)
+	}
+	return true
+}
+
+func (client *WebsocketClient) announced(buf []byte) bool {
+	message, err := decodeMessage(buf)
+	if err != nil {
+		return false
+	}
+
+	token, ok := message["resume"].(string)
+	if !ok {
+		return false
+	}
+
+	client.Lock()
+	client.resumeToken = token
+	client.Unlock()
+	return true
+}
+
+func (client *WebsocketClient) forget(id int64) {
+	client.Lock()
+	delete(client.pending, id)
+	client.Unlock()
+}
+
+func decodeMessage(buf []byte) (goservice.APIData, error) {
+	var data goservice.APIData
+	var resolver = msgpack.DefaultDecoderContainerResolver
+	resolver.MapType = reflect.TypeOf(make(goservice.APIData))
+
+	var dec = msgpack.NewDecoder(bytes.NewReader(buf), &resolver)
+	if err := dec.Decode(&data); err != nil {
+		return nil, fmt.Errorf("Decode error: %v", err)
+	}
+	return data, nil
+}
+
+// numericId normalizes whichever integer type msgpack chose for the
+// echoed request id.
+func numericId(val interface{}) (int64, bool) {
+	var v = reflect.ValueOf(val)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return int64(v.Float()), true
+	}
+	return 0, false
+}
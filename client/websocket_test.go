@@ -0,0 +1,69 @@
+package client
+
+import (
+	"bytes"
+	"testing"
+
+	goservice "github.com/brendonh/go-service"
+	"github.com/ugorji/go-msgpack"
+)
+
+func frame(prefix byte, data goservice.APIData) []byte {
+	var w = new(bytes.Buffer)
+	w.WriteByte(prefix)
+	msgpack.NewEncoder(w).Encode(data)
+	return w.Bytes()
+}
+
+func TestWebsocketDispatch(t *testing.T) {
+	var pushes []string
+	var topics []string
+	var client = &WebsocketClient{
+		options: &Options{
+			OnPush: func(msg []byte) {
+				pushes = append(pushes, string(msg))
+			},
+			OnTopic: func(topic string, data goservice.APIData) {
+				topics = append(topics, topic)
+			},
+		},
+		pending: make(map[int64]chan goservice.APIData),
+	}
+
+	var reply = make(chan goservice.APIData, 1)
+	client.pending[3] = reply
+
+	for _, msg := range [][]byte{
+		frame('a', goservice.APIData{"id": 3, "success": true}),
+		frame('p', goservice.APIData{"topic": "news", "data": goservice.APIData{}}),
+		frame('s', goservice.APIData{"session": "s1", "resume": "s1.token"}),
+		[]byte("plain message"),
+		[]byte("pizza is ready"),
+		[]byte("sorry"),
+		[]byte("all done"),
+		frame('p', goservice.APIData{"no": "topic"}),
+		[]byte{},
+	} {
+		client.dispatch(msg)
+	}
+
+	if response := <-reply; response["success"] != true {
+		t.Errorf("Wrong reply: %v", response)
+	}
+	if len(topics) != 1 || topics[0] != "news" {
+		t.Errorf("Wrong topics: %v", topics)
+	}
+	if client.resumeToken != "s1.token" {
+		t.Errorf("Resume token not taken: %q", client.resumeToken)
+	}
+
+	var expected = []string{"plain message", "pizza is ready", "sorry", "all done", string(frame('p', goservice.APIData{"no": "topic"})), ""}
+	if len(pushes) != len(expected) {
+		t.Fatalf("Expected pushes %q, got %q", expected, pushes)
+	}
+	for i := range expected {
+		if pushes[i] != expected[i] {
+			t.Errorf("Push %d: expected %q, got %q", i, expected[i], pushes[i])
+		}
+	}
+}
@@ -0,0 +1,208 @@
+// goservice-console runs console commands against a TelnetEndpoint
+// (or one listening on a unix socket) without a person at the
+// keyboard, e.g. from cron or CI:
+//
+//   goservice-console -addr localhost:9000 stats
+//   goservice-console -unix /var/run/app.sock users get id=42
+//   goservice-console -unix /var/run/app.sock -f nightly.txt
+//
+// With a command on the command line it runs just that; otherwise it
+// runs one command per line from -f or standard input, skipping blank
+// lines and lines starting with '#'. Each response is printed as one
+// line of JSON with a "status" field, and the exit status is the
+// highest of those: 0 success, 1 failure, 2 call error, 3 access
+// denied. It exits with 4 if the console couldn't be used at all.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+const exitUnavailable = 4
+
+var (
+	addr = flag.String("addr", "", "host:port of the telnet endpoint")
+	unixPath = flag.String("unix", "", "path of a unix socket endpoint")
+	user = flag.String("user", "", "username, if the console requires login")
+	password = flag.String("password", "", "password (default $GOSERVICE_PASSWORD)")
+	file = flag.String("f", "", "read commands from this file ('-' for standard input)")
+	timeout = flag.Duration("timeout", 30 * time.Second, "time to wait for each response")
+	keepGoing = flag.Bool("k", false, "keep running commands after one fails")
+)
+
+type console struct {
+	conn net.Conn
+	reader *bufio.Reader
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s (-addr host:port | -unix path) [options] [command [arg...]]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	commands, err := readCommands()
+	if err != nil {
+		fail(err)
+	}
+
+	c, err := dial()
+	if err != nil {
+		fail(err)
+	}
+	defer c.conn.Close()
+
+	var status = 0
+	for _, command := range commands {
+		response, result, err := c.run(command)
+		if err != nil {
+			fail(err)
+		}
+		fmt.Println(response)
+
+		if result > status {
+			status = result
+		}
+		if result != 0 && !*keepGoing {
+			break
+		}
+	}
+	os.Exit(status)
+}
+
+func fail(err error) {
+	fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[0], err)
+	os.Exit(exitUnavailable)
+}
+
+func readCommands() ([]string, error) {
+	if flag.NArg() > 0 {
+		var words []string
+		for _, arg := range flag.Args() {
+			words = append(words, quoteWord(arg))
+		}
+		return []string{strings.Join(words, " ")}, nil
+	}
+
+	var input io.Reader = os.Stdin
+	if *file != "" && *file != "-" {
+		f, err := os.Open(*file)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		input = f
+	}
+
+	var commands []string
+	var scanner = bufio.NewScanner(input)
+	for scanner.Scan() {
+		var line = strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		commands = append(commands, line)
+	}
+	return commands, scanner.Err()
+}
+
+// quoteWord single-quotes a word the way the console's tokenizer
+// expects, so arguments survive as the shell passed them.
+func quoteWord(word string) string {
+	return "'" + strings.Replace(word, "'", `'\''`, -1) + "'"
+}
+
+// dial connects, logs in if asked to, and switches the console to
+// script mode. Login prompts and the banner are skipped by waiting
+// for the first JSON line, which acknowledges "script".
+func dial() (*console, error) {
+	var network, address = "tcp", *addr
+	if *unixPath != "" {
+		network, address = "unix", *unixPath
+	}
+	if address == "" {
+		return nil, errors.New("one of -addr or -unix is required")
+	}
+
+	conn, err := net.DialTimeout(network, address, *timeout)
+	if err != nil {
+		return nil, err
+	}
+	var c = &console{conn: conn, reader: bufio.NewReader(conn)}
+
+	if *user != "" {
+		if err := c.login(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	if _, _, err := c.run("script"); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *console) login() error {
+	var pass = *password
+	if pass == "" {
+		pass = os.Getenv("GOSERVICE_PASSWORD")
+	}
+
+	c.conn.SetDeadline(time.Now().Add(*timeout))
+	if _, err := io.WriteString(c.conn, *user + "\n" + pass + "\n"); err != nil {
+		return err
+	}
+
+	for {
+		line, err := c.reader.ReadString('\n')
+		if strings.Contains(line, "Logged in as") {
+			return nil
+		}
+		if strings.Contains(line, "Login incorrect") || err == io.EOF {
+			return errors.New("login failed")
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// run sends one command line and returns the JSON line answering it,
+// with its status.
+func (c *console) run(command string) (string, int, error) {
+	c.conn.SetDeadline(time.Now().Add(*timeout))
+
+	if _, err := io.WriteString(c.conn, command + "\n"); err != nil {
+		return "", 0, err
+	}
+
+	for {
+		line, err := c.reader.ReadString('\n')
+		if err != nil {
+			return "", 0, err
+		}
+		line = strings.TrimSpace(line)
+
+		// Pushes and watch results can arrive in between; they have
+		// no status, or a "watch" id, respectively.
+		var response struct {
+			Status *int `json:"status"`
+			Watch *int `json:"watch"`
+		}
+		if json.Unmarshal([]byte(line), &response) == nil && response.Status != nil && response.Watch == nil {
+			return line, *response.Status, nil
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package goservice
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HttpSessionConnection queues everything sent to its session until
+// the client collects it from the long-poll or Server-Sent Events
+// route.
+type HttpSessionConnection struct {
+	info ConnectionInfo
+	queue [][]byte
+	notify chan bool
+	closed bool
+	sync.Mutex
+}
+
+const (
+	maxQueuedHttpMessages = 1000
+	defaultPollTimeout = 25 * time.Second
+	maxPollTimeout = 60 * time.Second
+	eventsKeepalive = 15 * time.Second
+)
+
+func NewHttpSessionConnection(req *http.Request) *HttpSessionConnection {
+	return &HttpSessionConnection{
+		info: ConnectionInfo{
+			Endpoint: "http",
+			RemoteAddr: req.RemoteAddr,
+			UserAgent: req.UserAgent(),
+		},
+		notify: make(chan bool),
+	}
+}
+
+func (sessConn *HttpSessionConnection) Info() ConnectionInfo {
+	return sessConn.info
+}
+
+func (sessConn *HttpSessionConnection) Send(msg []byte) {
+	sessConn.Lock()
+	defer sessConn.Unlock()
+
+	if sessConn.closed {
+		return
+	}
+
+	if len(sessConn.queue) >= maxQueuedHttpMessages {
+		sessConn.queue = sessConn.queue[1:]
+	}
+	sessConn.queue = append(sessConn.queue, msg)
+
+	close(sessConn.notify)
+	sessConn.notify = make(chan bool)
+}
+
+// Push sends data as JSON. Data that can't be encoded is dropped,
+// since the client couldn't be given it anyway.
+func (sessConn *HttpSessionConnection) Push(data APIData) {
+	msg, err := json.Marshal(data)
+	if err != nil {
+		return
+	}
+	sessConn.Send(msg)
+}
+
+func (sessConn *HttpSessionConnection) Close() {
+	sessConn.Lock()
+	defer sessConn.Unlock()
+
+	if sessConn.closed {
+		return
+	}
+	sessConn.closed = true
+	sessConn.queue = nil
+	close(sessConn.notify)
+}
+
+// Wait returns queued messages as soon as there are any, or nil once
+// timeout passes or the connection closes.
+func (sessConn *HttpSessionConnection) Wait(timeout time.Duration) [][]byte {
+	var deadline = time.After(timeout)
+	for {
+		sessConn.Lock()
+		if len(sessConn.queue) > 0 || sessConn.closed {
+			var queue = sessConn.queue
+			sessConn.queue = nil
+			sessConn.Unlock()
+			return queue
+		}
+		var notify = sessConn.notify
+		sessConn.Unlock()
+
+		select {
+		case <-notify:
+		case <-deadline:
+			return nil
+		}
+	}
+}
+
+func (sessConn *HttpSessionConnection) isClosed() bool {
+	sessConn.Lock()
+	defer sessConn.Unlock()
+	return sessConn.closed
+}
+
+
+// ------------------------------------------
+// Routes
+// ------------------------------------------
+
+// ServePoll waits up to ?timeout= seconds for messages sent to the
+// request's session and returns them as {"messages": [...]}. Messages
+// that are JSON are embedded as-is, anything else as a string.
+func (endpoint *HttpRpcEndpoint) ServePoll(response http.ResponseWriter, req *http.Request) {
+	var timeout = defaultPollTimeout
+	if seconds, err := strconv.Atoi(req.URL.Query().Get("timeout")); err == nil && seconds >= 0 {
+		timeout = time.Duration(seconds) * time.Second
+		if timeout > maxPollTimeout {
+			timeout = maxPollTimeout
+		}
+	}
+
+	session, sessConn, ok := endpoint.pushSession(response, req)
+	if !ok {
+		return
+	}
+	defer endpoint.release(session, req, response)
+
+	var messages = make([]interface{}, 0)
+	for _, msg := range sessConn.Wait(timeout) {
+		if json.Valid(msg) {
+			messages = append(messages, json.RawMessage(msg))
+		} else {
+			messages = append(messages, string(msg))
+		}
+	}
+
+	jsonReply, _ := json.Marshal(APIData{"messages": messages})
+	response.Header().Add("Content-Type", "application/json")
+	response.Header().Add("Cache-Control", "no-cache")
+	response.Header().Add("Content-Length", strconv.Itoa(len(jsonReply)))
+	response.Write(jsonReply)
+}
+
+// ServeEvents streams messages sent to the request's session as
+// Server-Sent Events until the client goes away or the session's
+// connection is closed.
+func (endpoint *HttpRpcEndpoint) ServeEvents(response http.ResponseWriter, req *http.Request) {
+	flusher, ok := response.(http.Flusher)
+	if !ok {
+		http.Error(response, "Streaming unsupported", 500)
+		return
+	}
+
+	session, sessConn, ok := endpoint.pushSession(response, req)
+	if !ok {
+		return
+	}
+	defer endpoint.release(session, req, response)
+
+	response.Header().Set("Content-Type", "text/event-stream")
+	response.Header().Set("Cache-Control", "no-cache")
+	response.WriteHeader(200)
+	flusher.Flush()
+
+	for !sessConn.isClosed() {
+		var messages = sessConn.Wait(eventsKeepalive)
+
+		var err error
+		if len(messages) == 0 {
+			_, err = response.Write([]byte(": keepalive\n\n"))
+		}
+		for _, msg := range messages {
+			if err = writeEvent(response, msg); err != nil {
+				break
+			}
+		}
+		if err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+func writeEvent(response http.ResponseWriter, msg []byte) error {
+	var event = "data: " + strings.Replace(string(msg), "\n", "\ndata: ", -1) + "\n\n"
+	_, err := response.Write([]byte(event))
+	return err
+}
+
+func (endpoint *HttpRpcEndpoint) pushSession(response http.ResponseWriter, req *http.Request) (Session, *HttpSessionConnection, bool) {
+	session, err := endpoint.resolver(req, response, endpoint)
+	if err != nil {
+		response.Header().Add("Content-Type", "text/plain")
+		response.WriteHeader(400)
+		response.Write([]byte(fmt.Sprintf("Session rejected: %s", err.Error())))
+		return nil, nil, false
+	}
+
+	if sessConn := httpConnection(session); sessConn != nil {
+		return session, sessConn, true
+	}
+
+	endpoint.release(session, req, response)
+	response.Header().Add("Content-Type", "text/plain")
+	response.WriteHeader(400)
+	response.Write([]byte("Session has no HTTP connection"))
+	return nil, nil, false
+}
+
+// httpConnection finds the session's HTTP connection, which may be one
+// of several.
+func httpConnection(session Session) *HttpSessionConnection {
+	if multi, ok := session.(MultiConnectionSession); ok {
+		for _, conn := range multi.Connections() {
+			if sessConn, ok := conn.(*HttpSessionConnection); ok {
+				return sessConn
+			}
+		}
+		return nil
+	}
+
+	if connected, ok := session.(ConnectedSession); ok {
+		if sessConn, ok := connected.Connection().(*HttpSessionConnection); ok {
+			return sessConn
+		}
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package goservice
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHttpSessionConnection(t *testing.T) {
+	var sessConn = NewHttpSessionConnection(httptest.NewRequest("GET", "/", nil))
+
+	if messages := sessConn.Wait(10 * time.Millisecond); messages != nil {
+		t.Errorf("Messages before any were sent: %q", messages)
+	}
+
+	for i := 0; i < maxQueuedHttpMessages + 1; i++ {
+		sessConn.Send([]byte{byte(i)})
+	}
+	var messages = sessConn.Wait(time.Second)
+	if len(messages) != maxQueuedHttpMessages || messages[0][0] != 1 {
+		t.Errorf("Queue not capped: %d messages, first %v", len(messages), messages[0])
+	}
+
+	var done = make(chan [][]byte)
+	go func() {
+		done <- sessConn.Wait(time.Minute)
+	}()
+	sessConn.Push(APIData{"hello": "there"})
+	if messages := <-done; len(messages) != 1 || string(messages[0]) != `{"hello":"there"}` {
+		t.Errorf("Wrong messages: %q", messages)
+	}
+
+	go func() {
+		done <- sessConn.Wait(time.Minute)
+	}()
+	sessConn.Close()
+	if messages := <-done; messages != nil {
+		t.Errorf("Messages after close: %q", messages)
+	}
+	sessConn.Send([]byte("late"))
+	if messages := sessConn.Wait(0); messages != nil {
+		t.Errorf("Sent after close: %q", messages)
+	}
+}
+
+func TestHttpPoll(t *testing.T) {
+	tracker, endpoint := testSessionTracker(SessionTrackerOptions{})
+	session, _, _ := resolve(tracker, endpoint, jsonRequest("/test/echo", "{}"))
+
+	var poll = func(token string) (*httptest.ResponseRecorder, APIData) {
+		var req = httptest.NewRequest("GET", "/_poll?timeout=0", nil)
+		req.Header.Set("Authorization", "Bearer " + token)
+		return serveHttp(http.HandlerFunc(endpoint.ServePoll), req)
+	}
+
+	session.Push(APIData{"hello": "there"})
+	session.Send([]byte("not json"))
+
+	recorder, response := poll(tracker.Token(session))
+	var messages, _ = response["messages"].([]interface{})
+	if recorder.Code != 200 || len(messages) != 2 {
+		t.Fatalf("Wrong poll: %d %s", recorder.Code, recorder.Body)
+	}
+	if messages[0].(APIData)["hello"] != "there" || messages[1] != "not json" {
+		t.Errorf("Wrong messages: %v", messages)
+	}
+
+	if _, response := poll(tracker.Token(session)); len(response["messages"].([]interface{})) != 0 {
+		t.Errorf("Messages delivered twice: %v", response)
+	}
+
+	if recorder, _ := poll(session.ID() + ".forged"); recorder.Code != 400 {
+		t.Errorf("Forged token polled: %d", recorder.Code)
+	}
+}
+
+func TestHttpEvents(t *testing.T) {
+	tracker, endpoint := testSessionTracker(SessionTrackerOptions{})
+	session, _, _ := resolve(tracker, endpoint, jsonRequest("/test/echo", "{}"))
+
+	var server = httptest.NewServer(http.HandlerFunc(endpoint.ServeEvents))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	req.Header.Set("Authorization", "Bearer " + tracker.Token(session))
+	stream, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer stream.Body.Close()
+	if stream.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("Wrong content type: %s", stream.Header.Get("Content-Type"))
+	}
+
+	session.Push(APIData{"hello": "there"})
+	session.Send([]byte("two\nlines"))
+
+	var reader = bufio.NewReader(stream.Body)
+	var expected = []string{
+		`data: {"hello":"there"}`, "",
+		"data: two", "data: lines", "",
+	}
+	for _, want := range expected {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Stream ended early: %v", err)
+		}
+		if line != want + "\n" {
+			t.Errorf("Got %q, expected %q", line, want)
+		}
+	}
+
+	var ended = make(chan error)
+	go func() {
+		_, err := ioutil.ReadAll(reader)
+		ended <- err
+	}()
+	httpConnection(session).Close()
+	select {
+	case err := <-ended:
+		if err != nil {
+			t.Errorf("Stream broke: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("Stream still open after the connection closed")
+	}
+}
@@ -6,17 +6,25 @@ import (
 	"net/http"
 	"strings"
 	"strconv"
+	"errors"
+	"io/ioutil"
 	"encoding/json"
 )
 
 type SessionResolver func(*http.Request, http.ResponseWriter, *HttpRpcEndpoint) (Session, error)
 
+// SessionReleaser is called once a request is finished with its
+// session. For calls, that is before the response is written, so it
+// may still set headers.
+type SessionReleaser func(Session, *http.Request, http.ResponseWriter, *HttpRpcEndpoint)
+
 type HttpRpcEndpoint struct {
 	Address string
 	Mux *http.ServeMux
 	listener net.Listener
 	context ServerContext
 	resolver SessionResolver
+	releaser SessionReleaser
 	stripLength int
 	logPrefix string
 }
@@ -24,16 +32,26 @@ type HttpRpcEndpoint struct {
 
 type HttpRpcEndpointOptions struct {
 	Resolver SessionResolver
+	Releaser SessionReleaser
 	Static bool
 	StaticPath string
 	StaticUri string
 	APIUri string
+	BatchUri string
+	JSONRPCUri string
+	PollUri string
+	EventsUri string
 }
 
 var defaultOptions = &HttpRpcEndpointOptions{
 	Resolver: DefaultSessionResolver,
+	Releaser: DefaultSessionReleaser,
 	Static: false,
 	APIUri: "/",
+	BatchUri: "/_batch",
+	JSONRPCUri: "/_jsonrpc",
+	PollUri: "/_poll",
+	EventsUri: "/_events",
 }
 
 func NewHttpRpcEndpoint(address string, context ServerContext, options *HttpRpcEndpointOptions) Endpoint {
@@ -53,29 +71,51 @@ func NewHttpRpcEndpoint(address string, context ServerContext, options *HttpRpcE
 		Address: address,
 		context: context,
 		resolver: options.Resolver,
+		releaser: options.Releaser,
 		stripLength: len(options.APIUri),
 		logPrefix: "HTTP " + address,
 	}
 	mux.Handle(options.APIUri, endpoint)
 
-	return endpoint
-}
+	if options.BatchUri != "" {
+		mux.HandleFunc(options.BatchUri, endpoint.ServeBatch)
+	}
 
+	if options.JSONRPCUri != "" {
+		mux.HandleFunc(options.JSONRPCUri, endpoint.ServeJSONRPC)
+	}
 
-type HttpSessionConnection struct {
-}
+	if options.PollUri != "" {
+		mux.HandleFunc(options.PollUri, endpoint.ServePoll)
+	}
+
+	if options.EventsUri != "" {
+		mux.HandleFunc(options.EventsUri, endpoint.ServeEvents)
+	}
 
-func (sessConn *HttpSessionConnection) Send(msg []byte) {
-	fmt.Printf("HTTP session send: %v\n", msg)
+	return endpoint
 }
 
 
+// DefaultSessionResolver creates a fresh session for every request.
+// Use a SessionTracker to keep sessions across requests.
 func DefaultSessionResolver(req *http.Request, response http.ResponseWriter, endpoint *HttpRpcEndpoint) (Session, error) {
-	// XXX TODO: Session tracking, sending
-	var sender = &HttpSessionConnection{}
+	var sender = NewHttpSessionConnection(req)
 	return endpoint.context.CreateSession(sender), nil
 }
 
+// DefaultSessionReleaser closes the per-request sessions made by
+// DefaultSessionResolver.
+func DefaultSessionReleaser(session Session, req *http.Request, response http.ResponseWriter, endpoint *HttpRpcEndpoint) {
+	endpoint.context.SessionClosed(session, "request finished")
+}
+
+func (endpoint *HttpRpcEndpoint) release(session Session, req *http.Request, response http.ResponseWriter) {
+	if endpoint.releaser != nil {
+		endpoint.releaser(session, req, response, endpoint)
+	}
+}
+
 func (endpoint *HttpRpcEndpoint) Start() bool {
 	if endpoint.listener != nil {
 		return false
@@ -127,32 +167,128 @@ func (endpoint *HttpRpcEndpoint) ServeHTTP(response http.ResponseWriter, req *ht
 		return
 	}
 
-	req.ParseForm()
-
-	var form = make(APIData)
-	for k, v := range req.Form {
-		form[k] = v[0]
+	form, err := readRequestData(req)
+	if err != nil {
+		response.Header().Add("Content-Type", "text/plain")
+		response.WriteHeader(400)
+		response.Write([]byte(fmt.Sprintf("Invalid request: %s", err.Error())))
+		return
 	}
 
-	var session, err = endpoint.resolver(req, response, endpoint)
+	session, err := endpoint.resolver(req, response, endpoint)
 	if err != nil {
-		response.WriteHeader(400)
 		response.Header().Add("Content-Type", "text/plain")
+		response.WriteHeader(400)
 		response.Write([]byte(fmt.Sprintf("Session rejected: %s", err.Error())))
 		return
 	}
 
 	ok, errors, resp := endpoint.context.API().HandleCall(
 		bits[0], bits[1], form, session, endpoint.context)
-
-	if errors != nil {
-		response.WriteHeader(400)
-	}
+	endpoint.release(session, req, response)
 
 	response.Header().Add("Content-Type", "application/json")
 
 	jsonReply, _ := json.Marshal(Response(ok, errors, resp))
 	response.Header().Add("Content-Length", strconv.Itoa(len(jsonReply)))
 
+	switch {
+	case AccessDenied(errors) == DeniedUnauthorized:
+		response.WriteHeader(401)
+	case AccessDenied(errors) == DeniedForbidden:
+		response.WriteHeader(403)
+	case errors != nil:
+		response.WriteHeader(400)
+	}
+
 	response.Write(jsonReply)
+}
+
+// ServeBatch accepts a JSON body of the form
+// {"batch": [{service, method, data, id}, ...], "parallel": bool, "stopOnError": bool}
+func (endpoint *HttpRpcEndpoint) ServeBatch(response http.ResponseWriter, req *http.Request) {
+	request, err := readRequestData(req)
+	if err == nil && !IsBatch(request) {
+		err = errors.New("Missing batch")
+	}
+	if err != nil {
+		response.Header().Add("Content-Type", "text/plain")
+		response.WriteHeader(400)
+		response.Write([]byte(fmt.Sprintf("Invalid request: %s", err.Error())))
+		return
+	}
+
+	session, err := endpoint.resolver(req, response, endpoint)
+	if err != nil {
+		response.Header().Add("Content-Type", "text/plain")
+		response.WriteHeader(400)
+		response.Write([]byte(fmt.Sprintf("Session rejected: %s", err.Error())))
+		return
+	}
+
+	var reply = endpoint.context.API().HandleRequest(request, session, endpoint.context)
+	endpoint.release(session, req, response)
+
+	jsonReply, _ := json.Marshal(reply)
+	response.Header().Add("Content-Type", "application/json")
+	response.Header().Add("Content-Length", strconv.Itoa(len(jsonReply)))
+	response.Write(jsonReply)
+}
+
+// ServeJSONRPC answers JSON-RPC 2.0 requests POSTed as the body.
+func (endpoint *HttpRpcEndpoint) ServeJSONRPC(response http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		response.Header().Add("Allow", "POST")
+		response.WriteHeader(405)
+		return
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		response.WriteHeader(400)
+		return
+	}
+
+	session, err := endpoint.resolver(req, response, endpoint)
+	if err != nil {
+		response.Header().Add("Content-Type", "text/plain")
+		response.WriteHeader(400)
+		response.Write([]byte(fmt.Sprintf("Session rejected: %s", err.Error())))
+		return
+	}
+
+	var reply = HandleJSONRPC(body, session, endpoint.context)
+	endpoint.release(session, req, response)
+	if reply == nil {
+		response.WriteHeader(204)
+		return
+	}
+
+	response.Header().Add("Content-Type", "application/json")
+	response.Header().Add("Content-Length", strconv.Itoa(len(reply)))
+	response.Write(reply)
+}
+
+// readRequestData reads call arguments from a JSON object body, which
+// nested arguments need, or otherwise from the query string or form.
+func readRequestData(req *http.Request) (APIData, error) {
+	if strings.HasPrefix(req.Header.Get("Content-Type"), "application/json") {
+		var body interface{}
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			return nil, err
+		}
+		data, ok := ToAPIData(body).(APIData)
+		if !ok {
+			return nil, errors.New("JSON body must be an object")
+		}
+		return data, nil
+	}
+
+	req.ParseForm()
+
+	var form = make(APIData)
+	for k, v := range req.Form {
+		form[k] = v[0]
+	}
+	return form, nil
 }
\ No newline at end of file
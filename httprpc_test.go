@@ -0,0 +1,87 @@
+package goservice
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func testHttpEndpoint() *HttpRpcEndpoint {
+	var harness = NewHarness(testAPI(), nil)
+	return NewHttpRpcEndpoint("", harness.Server, nil).(*HttpRpcEndpoint)
+}
+
+func serveHttp(handler http.Handler, req *http.Request) (*httptest.ResponseRecorder, APIData) {
+	var recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+
+	var response APIData
+	if strings.HasPrefix(recorder.Header().Get("Content-Type"), "application/json") {
+		var decoded interface{}
+		json.Unmarshal(recorder.Body.Bytes(), &decoded)
+		response, _ = ToAPIData(decoded).(APIData)
+	}
+	return recorder, response
+}
+
+func jsonRequest(path string, body string) *http.Request {
+	var req = httptest.NewRequest("POST", path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	return req
+}
+
+func formRequest(path string, form url.Values) *http.Request {
+	var req = httptest.NewRequest("POST", path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req
+}
+
+func TestHttpRpcCall(t *testing.T) {
+	var endpoint = testHttpEndpoint()
+
+	for _, req := range []*http.Request{
+		jsonRequest("/test/echo", `{"text": "hi"}`),
+		formRequest("/test/echo", url.Values{"text": {"hi"}}),
+	} {
+		recorder, response := serveHttp(endpoint, req)
+		if recorder.Code != 200 || response["data"].(APIData)["text"] != "hi" {
+			t.Errorf("%s: %d %s", req.Header.Get("Content-Type"), recorder.Code, recorder.Body)
+		}
+	}
+}
+
+func TestHttpRpcErrors(t *testing.T) {
+	var endpoint = testHttpEndpoint()
+
+	var cases = []struct {
+		req *http.Request
+		code int
+		reason string
+	}{
+		{jsonRequest("/test/echo", `{}`), 400, "call error"},
+		{jsonRequest("/test/nope", `{}`), 400, "call error"},
+		{jsonRequest("/test/whoami", `{}`), 401, "unauthorized"},
+		{jsonRequest("/test/fail", `{}`), 200, "failure"},
+		{jsonRequest("/test/echo", `{"text": `), 400, ""},
+		{jsonRequest("/test/echo", `["text"]`), 400, ""},
+	}
+
+	for i, c := range cases {
+		recorder, response := serveHttp(endpoint, c.req)
+		if recorder.Code != c.code {
+			t.Errorf("Case %d: expected %d, got %d %s", i, c.code, recorder.Code, recorder.Body)
+		}
+		if c.reason == "" {
+			if response != nil {
+				t.Errorf("Case %d: bad request answered with JSON", i)
+			}
+			continue
+		}
+		if response == nil || response["reason"] != c.reason {
+			t.Errorf("Case %d: expected %s, got %s", i, c.reason, recorder.Body)
+		}
+	}
+}
@@ -0,0 +1,370 @@
+package goservice
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+type SessionTrackerOptions struct {
+	// Secret signs session tokens. It must be set; NewSessionTracker
+	// panics without it.
+	Secret []byte
+
+	CookieName string
+	CookiePath string
+	SecureCookie bool
+
+	// IdleTimeout expires sessions not seen for that long, MaxAge
+	// expires them regardless of use. IdleTimeout defaults to
+	// DefaultSessionIdleTimeout, so that the sessions of clients that
+	// never come back don't pile up; MaxAge defaults to no limit. A
+	// negative value disables either check.
+	IdleTimeout time.Duration
+	MaxAge time.Duration
+
+	// Store holds session records between requests. It defaults to a
+	// MemorySessionStore; use a FileSessionStore to survive restarts.
+	Store SessionStore
+}
+
+// SessionTracker keeps HTTP sessions alive across requests. A session
+// is identified by a signed token, carried either in a cookie or in an
+// "Authorization: Bearer" header. New tokens are also returned in the
+// X-Session-Token response header for clients that don't keep cookies.
+//
+// Since browsers send cookies with requests other sites make too, the
+// cookie is only accepted on requests a cross-site form or link can't
+// make: JSON POSTs, requests with an X-Requested-With header, and
+// EventSource streams. Other requests carrying it are rejected.
+//
+// A session gets a new token whenever it logs in or out, so that one
+// planted on a client beforehand (session fixation) stops working.
+//
+// Use tracker.Resolve and tracker.Release as the HttpRpcEndpointOptions
+// Resolver and Releaser.
+type SessionTracker struct {
+	options SessionTrackerOptions
+	live map[string]*trackedSession
+	lastSweep time.Time
+	sync.Mutex
+}
+
+// trackedSession remembers the key in a session's current token, and
+// which user it was issued for.
+type trackedSession struct {
+	session Session
+	key string
+	userID string
+}
+
+const (
+	sessionSweepInterval = time.Minute
+	DefaultSessionIdleTimeout = 30 * time.Minute
+)
+
+func NewSessionTracker(options SessionTrackerOptions) *SessionTracker {
+	if len(options.Secret) == 0 {
+		panic("SessionTracker needs a Secret")
+	}
+	if options.IdleTimeout == 0 {
+		options.IdleTimeout = DefaultSessionIdleTimeout
+	}
+	if options.CookieName == "" {
+		options.CookieName = "goservice_session"
+	}
+	if options.CookiePath == "" {
+		options.CookiePath = "/"
+	}
+	if options.Store == nil {
+		options.Store = NewMemorySessionStore()
+	}
+
+	return &SessionTracker{
+		options: options,
+		live: make(map[string]*trackedSession),
+		lastSweep: time.Now(),
+	}
+}
+
+func (tracker *SessionTracker) Resolve(req *http.Request, response http.ResponseWriter, endpoint *HttpRpcEndpoint) (Session, error) {
+	tracker.sweep(endpoint.context)
+
+	token, bearer := tracker.requestToken(req)
+	if token != "" && !bearer && !cookieAllowed(req) {
+		return nil, errors.New("session cookie needs a JSON body or an X-Requested-With header")
+	}
+	if token != "" {
+		id, key, ok := tracker.verify(token)
+		if !ok {
+			if bearer {
+				return nil, errors.New("invalid session token")
+			}
+		} else if session := tracker.find(id, key, req, endpoint.context); session != nil {
+			return session, nil
+		} else if bearer {
+			return nil, errors.New("session expired")
+		}
+	}
+
+	var session = endpoint.context.CreateSession(NewHttpSessionConnection(req))
+	var tracked = &trackedSession{
+		session: session,
+		key: randomToken(),
+		userID: sessionUserID(session),
+	}
+
+	tracker.Lock()
+	tracker.live[session.ID()] = tracked
+	tracker.Unlock()
+
+	tracker.save(session, tracked.key, endpoint.context)
+	tracker.issue(req, response, tracker.token(session.ID(), tracked.key))
+
+	return session, nil
+}
+
+// Lookup returns the tracked session a request's token refers to,
+// without creating one. Use it as a WebsocketEndpoint's Join to let
+// websockets join HTTP sessions, setting its Origins if the pages
+// aren't served from the websocket's own host.
+func (tracker *SessionTracker) Lookup(req *http.Request, context ServerContext) Session {
+	token, _ := tracker.requestToken(req)
+	if token == "" {
+		return nil
+	}
+
+	id, key, ok := tracker.verify(token)
+	if !ok {
+		return nil
+	}
+	return tracker.find(id, key, req, context)
+}
+
+// Release saves any changes the request made to the session, and
+// issues a new token if it logged in or out.
+func (tracker *SessionTracker) Release(session Session, req *http.Request, response http.ResponseWriter, endpoint *HttpRpcEndpoint) {
+	var userID = sessionUserID(session)
+
+	tracker.Lock()
+	var tracked = tracker.live[session.ID()]
+	if tracked == nil || tracked.session != session {
+		tracker.Unlock()
+		return
+	}
+	var renewed = tracked.userID != userID
+	if renewed {
+		tracked.key, tracked.userID = randomToken(), userID
+	}
+	var key = tracked.key
+	tracker.Unlock()
+
+	tracker.save(session, key, endpoint.context)
+	if renewed {
+		tracker.issue(req, response, tracker.token(session.ID(), key))
+	}
+}
+
+// Token returns the signed token identifying session, or "" if the
+// tracker doesn't know it.
+func (tracker *SessionTracker) Token(session Session) string {
+	tracker.Lock()
+	defer tracker.Unlock()
+
+	var tracked = tracker.live[session.ID()]
+	if tracked == nil || tracked.session != session {
+		return ""
+	}
+	return tracker.token(session.ID(), tracked.key)
+}
+
+// Forget drops a session, e.g. on logout, so its token stops working.
+func (tracker *SessionTracker) Forget(session Session, context ServerContext) {
+	tracker.drop(session.ID())
+	context.SessionClosed(session, "forgotten")
+}
+
+func (tracker *SessionTracker) requestToken(req *http.Request) (string, bool) {
+	var auth = req.Header.Get("Authorization")
+	if strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimSpace(auth[len("Bearer "):]), true
+	}
+
+	if cookie, err := req.Cookie(tracker.options.CookieName); err == nil {
+		return cookie.Value, false
+	}
+
+	return "", false
+}
+
+func (tracker *SessionTracker) issue(req *http.Request, response http.ResponseWriter, token string) {
+	http.SetCookie(response, &http.Cookie{
+		Name: tracker.options.CookieName,
+		Value: token,
+		Path: tracker.options.CookiePath,
+		Secure: tracker.options.SecureCookie || req.TLS != nil,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	response.Header().Set("X-Session-Token", token)
+}
+
+func (tracker *SessionTracker) save(session Session, key string, context ServerContext) {
+	var record = NewSessionRecord(session)
+	record.TokenKey = key
+	if err := tracker.options.Store.Save(record); err != nil {
+		context.Log("Error saving session %s: %v", session.ID(), err)
+	}
+}
+
+// cookieAllowed reports whether req may be carried by the session
+// cookie. EventSource can't set headers, but streams only read the
+// session's messages, and SameSite keeps the cookie off other sites'
+// background requests.
+func cookieAllowed(req *http.Request) bool {
+	if req.Header.Get("X-Requested-With") != "" {
+		return true
+	}
+	if req.Method == "GET" || req.Method == "HEAD" {
+		return req.Header.Get("Accept") == "text/event-stream"
+	}
+	return strings.HasPrefix(req.Header.Get("Content-Type"), "application/json")
+}
+
+// find returns the live session for id, restoring it from the store
+// if the server has restarted since it was created. Tokens with an
+// outdated key find nothing.
+func (tracker *SessionTracker) find(id string, key string, req *http.Request, context ServerContext) Session {
+	tracker.Lock()
+	var tracked = tracker.live[id]
+	var current bool
+	if tracked != nil {
+		current = tracked.key == key
+	}
+	tracker.Unlock()
+
+	// Kicked sessions are no longer registered
+	if tracked != nil && context.Sessions().Find(id) != tracked.session {
+		tracker.drop(id)
+		return nil
+	}
+
+	record, err := tracker.options.Store.Load(id)
+	if err != nil {
+		context.Log("Error loading session %s: %v", id, err)
+		return nil
+	}
+
+	if record == nil || record.Expired(time.Now(), tracker.options.IdleTimeout, tracker.options.MaxAge) {
+		tracker.drop(id)
+		if tracked != nil {
+			context.SessionClosed(tracked.session, "expired")
+		}
+		return nil
+	}
+
+	if tracked == nil {
+		if record.TokenKey == "" || record.TokenKey != key {
+			return nil
+		}
+
+		var session = context.RestoreSession(record, NewHttpSessionConnection(req))
+		if session.ID() != id {
+			context.SessionClosed(session, "not restorable")
+			return nil
+		}
+
+		tracked = &trackedSession{
+			session: session,
+			key: key,
+			userID: sessionUserID(session),
+		}
+		tracker.Lock()
+		tracker.live[id] = tracked
+		tracker.Unlock()
+	} else if !current {
+		return nil
+	}
+
+	tracker.options.Store.Touch(id)
+	return tracked.session
+}
+
+func (tracker *SessionTracker) drop(id string) {
+	tracker.Lock()
+	delete(tracker.live, id)
+	tracker.Unlock()
+
+	tracker.options.Store.Delete(id)
+}
+
+func (tracker *SessionTracker) sweep(context ServerContext) {
+	var now = time.Now()
+
+	tracker.Lock()
+	if now.Sub(tracker.lastSweep) < sessionSweepInterval {
+		tracker.Unlock()
+		return
+	}
+	tracker.lastSweep = now
+	tracker.Unlock()
+
+	expired, err := tracker.options.Store.Expire(tracker.options.IdleTimeout, tracker.options.MaxAge)
+	if err != nil {
+		context.Log("Session store expiry failed: %v", err)
+	}
+
+	for _, id := range expired {
+		tracker.Lock()
+		var tracked = tracker.live[id]
+		delete(tracker.live, id)
+		tracker.Unlock()
+
+		if tracked != nil {
+			context.SessionClosed(tracked.session, "expired")
+		}
+	}
+}
+
+func (tracker *SessionTracker) sign(payload string) string {
+	var mac = hmac.New(sha256.New, tracker.options.Secret)
+	mac.Write([]byte(payload))
+	return base64.URLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (tracker *SessionTracker) token(id string, key string) string {
+	var payload = id + "." + key
+	return payload + "." + tracker.sign(payload)
+}
+
+// verify checks a token's signature and splits it into the session id
+// and key.
+func (tracker *SessionTracker) verify(token string) (string, string, bool) {
+	var dot = strings.LastIndex(token, ".")
+	if dot < 0 {
+		return "", "", false
+	}
+
+	var payload, signature = token[:dot], token[dot+1:]
+	if !hmac.Equal([]byte(signature), []byte(tracker.sign(payload))) {
+		return "", "", false
+	}
+
+	dot = strings.LastIndex(payload, ".")
+	if dot < 0 {
+		return "", "", false
+	}
+	return payload[:dot], payload[dot+1:], true
+}
+
+func sessionUserID(session Session) string {
+	if user := session.User(); user != nil {
+		return user.ID()
+	}
+	return ""
+}
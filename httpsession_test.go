@@ -0,0 +1,141 @@
+package goservice
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func testSessionTracker(options SessionTrackerOptions) (*SessionTracker, *HttpRpcEndpoint) {
+	options.Secret = []byte("secret")
+	var tracker = NewSessionTracker(options)
+
+	var harness = NewHarness(testAPI(), nil)
+	var endpoint = NewHttpRpcEndpoint("", harness.Server, &HttpRpcEndpointOptions{
+		Resolver: tracker.Resolve,
+		Releaser: tracker.Release,
+		APIUri: "/",
+	}).(*HttpRpcEndpoint)
+	return tracker, endpoint
+}
+
+func resolve(tracker *SessionTracker, endpoint *HttpRpcEndpoint, req *http.Request) (Session, *httptest.ResponseRecorder, error) {
+	var recorder = httptest.NewRecorder()
+	session, err := tracker.Resolve(req, recorder, endpoint)
+	return session, recorder, err
+}
+
+func TestSessionTrackerCookie(t *testing.T) {
+	tracker, endpoint := testSessionTracker(SessionTrackerOptions{})
+
+	session, recorder, err := resolve(tracker, endpoint, jsonRequest("/test/echo", "{}"))
+	if err != nil {
+		t.Fatalf("Resolve failed: %v", err)
+	}
+
+	var cookies = recorder.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("Wrong cookies: %v", cookies)
+	}
+	var cookie = cookies[0]
+	if cookie.Value != tracker.Token(session) || !cookie.HttpOnly ||
+		cookie.SameSite != http.SameSiteLaxMode || cookie.Secure {
+		t.Errorf("Wrong cookie: %v", cookie)
+	}
+	if recorder.Header().Get("X-Session-Token") != cookie.Value {
+		t.Errorf("Wrong X-Session-Token: %s", recorder.Header().Get("X-Session-Token"))
+	}
+
+	var withCookie = func(req *http.Request) *http.Request {
+		req.AddCookie(cookie)
+		return req
+	}
+
+	var form = formRequest("/test/echo", url.Values{})
+	var xhr = formRequest("/test/echo", url.Values{})
+	xhr.Header.Set("X-Requested-With", "test")
+	var events = httptest.NewRequest("GET", "/_events", nil)
+	events.Header.Set("Accept", "text/event-stream")
+
+	var cases = []struct {
+		req *http.Request
+		allowed bool
+	}{
+		{jsonRequest("/test/echo", "{}"), true},
+		{xhr, true},
+		{events, true},
+		{form, false},
+		{httptest.NewRequest("GET", "/test/echo", nil), false},
+	}
+
+	for _, c := range cases {
+		resolved, _, err := resolve(tracker, endpoint, withCookie(c.req))
+		if c.allowed && (err != nil || resolved != session) {
+			t.Errorf("%s %s: got %v, %v", c.req.Method, c.req.Header, resolved, err)
+		}
+		if !c.allowed && err == nil {
+			t.Errorf("%s %s: cookie accepted", c.req.Method, c.req.Header)
+		}
+	}
+
+	recorder, _ = serveHttp(endpoint, withCookie(formRequest("/test/echo", url.Values{"text": {"hi"}})))
+	if recorder.Code != 400 {
+		t.Errorf("Form call with cookie: %d %s", recorder.Code, recorder.Body)
+	}
+}
+
+func TestSessionTrackerSecureCookie(t *testing.T) {
+	tracker, endpoint := testSessionTracker(SessionTrackerOptions{})
+
+	var req = jsonRequest("/test/echo", "{}")
+	req.TLS = &tls.ConnectionState{}
+	_, recorder, _ := resolve(tracker, endpoint, req)
+
+	if cookies := recorder.Result().Cookies(); len(cookies) != 1 || !cookies[0].Secure {
+		t.Errorf("Cookie not secure over TLS: %v", cookies)
+	}
+}
+
+func TestSessionTrackerBearer(t *testing.T) {
+	tracker, endpoint := testSessionTracker(SessionTrackerOptions{})
+
+	session, _, _ := resolve(tracker, endpoint, jsonRequest("/test/echo", "{}"))
+
+	var bearer = func(token string) *http.Request {
+		var req = httptest.NewRequest("GET", "/test/echo", nil)
+		req.Header.Set("Authorization", "Bearer " + token)
+		return req
+	}
+
+	var token = tracker.Token(session)
+	if resolved, _, err := resolve(tracker, endpoint, bearer(token)); resolved != session {
+		t.Errorf("Bearer token not resolved: %v, %v", resolved, err)
+	}
+	if _, _, err := resolve(tracker, endpoint, bearer(session.ID() + ".forged")); err == nil {
+		t.Errorf("Forged token accepted")
+	}
+
+	tracker.Forget(session, endpoint.context)
+	if _, _, err := resolve(tracker, endpoint, bearer(token)); err == nil {
+		t.Errorf("Forgotten session's token accepted")
+	}
+}
+
+func TestSessionTrackerIdleTimeout(t *testing.T) {
+	tracker, endpoint := testSessionTracker(SessionTrackerOptions{IdleTimeout: 50 * time.Millisecond})
+
+	session, _, _ := resolve(tracker, endpoint, jsonRequest("/test/echo", "{}"))
+	time.Sleep(100 * time.Millisecond)
+
+	var req = jsonRequest("/test/echo", "{}")
+	req.Header.Set("Authorization", "Bearer " + tracker.Token(session))
+	if _, _, err := resolve(tracker, endpoint, req); err == nil {
+		t.Errorf("Idle session resolved")
+	}
+	if endpoint.context.Sessions().Find(session.ID()) != nil {
+		t.Errorf("Idle session still registered")
+	}
+}
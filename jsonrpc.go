@@ -0,0 +1,185 @@
+package goservice
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+)
+
+// Standard JSON-RPC 2.0 error codes, plus JSONRPCCallFailed for
+// handlers that return false.
+const (
+	JSONRPCParseError = -32700
+	JSONRPCInvalidRequest = -32600
+	JSONRPCMethodNotFound = -32601
+	JSONRPCInvalidParams = -32602
+	JSONRPCInternalError = -32603
+	JSONRPCCallFailed = -32000
+	JSONRPCUnauthorized = -32001
+	JSONRPCForbidden = -32003
+)
+
+type jsonRPCError struct {
+	Code int `json:"code"`
+	Message string `json:"message"`
+	Data interface{} `json:"data,omitempty"`
+}
+
+// HandleJSONRPC answers a JSON-RPC 2.0 request or batch. Methods are
+// named "service.method" and dispatched through API.HandleCall. The
+// returned reply is nil when there is nothing to send, i.e. when the
+// body held only notifications.
+func HandleJSONRPC(body []byte, session Session, context ServerContext) []byte {
+	var trimmed = bytes.TrimSpace(body)
+
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		var calls []json.RawMessage
+		if err := json.Unmarshal(trimmed, &calls); err != nil {
+			return jsonRPCEncode(jsonRPCErrorReply(nil, JSONRPCParseError, "Parse error", nil))
+		}
+		if len(calls) == 0 {
+			return jsonRPCEncode(jsonRPCErrorReply(nil, JSONRPCInvalidRequest, "Invalid Request", nil))
+		}
+
+		var replies []APIData
+		for _, call := range calls {
+			if reply := jsonRPCCall(call, session, context); reply != nil {
+				replies = append(replies, reply)
+			}
+		}
+		if len(replies) == 0 {
+			return nil
+		}
+		return jsonRPCEncode(replies)
+	}
+
+	var reply = jsonRPCCall(trimmed, session, context)
+	if reply == nil {
+		return nil
+	}
+	return jsonRPCEncode(reply)
+}
+
+func jsonRPCCall(raw json.RawMessage, session Session, context ServerContext) APIData {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		if _, isSyntax := err.(*json.SyntaxError); isSyntax {
+			return jsonRPCErrorReply(nil, JSONRPCParseError, "Parse error", nil)
+		}
+		return jsonRPCErrorReply(nil, JSONRPCInvalidRequest, "Invalid Request", nil)
+	}
+
+	id, hasId := fields["id"]
+
+	var version, fullName string
+	if json.Unmarshal(fields["jsonrpc"], &version) != nil || version != "2.0" ||
+		json.Unmarshal(fields["method"], &fullName) != nil {
+		return jsonRPCErrorReply(id, JSONRPCInvalidRequest, "Invalid Request", nil)
+	}
+
+	var reply = jsonRPCDispatch(fullName, fields["params"], session, context)
+	if !hasId {
+		return nil
+	}
+
+	reply["jsonrpc"] = "2.0"
+	reply["id"] = id
+	return reply
+}
+
+func jsonRPCDispatch(fullName string, rawParams json.RawMessage, session Session, context ServerContext) APIData {
+	var api = context.API()
+
+	var nameBits = strings.SplitN(fullName, ".", 2)
+	if len(nameBits) != 2 {
+		return jsonRPCErrorReply(nil, JSONRPCMethodNotFound, "Method not found", nil)
+	}
+
+	service, ok := api.GetServices()[nameBits[0]]
+	if !ok {
+		return jsonRPCErrorReply(nil, JSONRPCMethodNotFound, "Method not found", nil)
+	}
+
+	method := service.FindMethod(nameBits[1])
+	if method == nil {
+		return jsonRPCErrorReply(nil, JSONRPCMethodNotFound, "Method not found", nil)
+	}
+
+	params, ok := jsonRPCParams(rawParams, method)
+	if !ok {
+		return jsonRPCErrorReply(nil, JSONRPCInvalidParams, "Invalid params", nil)
+	}
+
+	ok, errors, response := api.HandleCall(
+		nameBits[0], nameBits[1], params, session, context)
+
+	if ok {
+		return APIData{"result": response}
+	}
+
+	switch AccessDenied(errors) {
+	case DeniedUnauthorized:
+		return jsonRPCErrorReply(nil, JSONRPCUnauthorized, "Unauthorized", nil)
+	case DeniedForbidden:
+		return jsonRPCErrorReply(nil, JSONRPCForbidden, "Forbidden", nil)
+	}
+
+	if errors != nil {
+		return jsonRPCErrorReply(nil, JSONRPCInvalidParams, "Invalid params", errors)
+	}
+
+	return jsonRPCErrorReply(nil, JSONRPCCallFailed, "Call failed", response)
+}
+
+// jsonRPCParams maps by-name params directly and by-position params
+// onto the method's ArgSpec order.
+func jsonRPCParams(raw json.RawMessage, method *APIMethod) (APIData, bool) {
+	if len(raw) == 0 {
+		return make(APIData), true
+	}
+
+	var params interface{}
+	if err := json.Unmarshal(raw, &params); err != nil {
+		return nil, false
+	}
+
+	params = ToAPIData(params)
+
+	switch params.(type) {
+	case APIData:
+		return params.(APIData), true
+	case []interface{}:
+		var list = params.([]interface{})
+		if len(list) > len(method.ArgSpec) {
+			return nil, false
+		}
+		var data = make(APIData)
+		for i, val := range list {
+			data[method.ArgSpec[i].Name] = val
+		}
+		return data, true
+	}
+
+	return nil, false
+}
+
+func jsonRPCErrorReply(id json.RawMessage, code int, message string, data interface{}) APIData {
+	return APIData{
+		"jsonrpc": "2.0",
+		"error": jsonRPCError{
+			Code: code,
+			Message: message,
+			Data: data,
+		},
+		"id": id,
+	}
+}
+
+func jsonRPCEncode(reply interface{}) []byte {
+	encoded, err := json.Marshal(reply)
+	if err != nil {
+		encoded, _ = json.Marshal(jsonRPCErrorReply(
+			nil, JSONRPCInternalError, "Internal error", nil))
+	}
+	return encoded
+}
@@ -0,0 +1,90 @@
+package goservice
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func jsonRPC(t *testing.T, body string) interface{} {
+	var harness = NewHarness(testAPI(), nil)
+	session, _ := harness.Connect()
+
+	var reply = HandleJSONRPC([]byte(body), session, harness.Server)
+	if reply == nil {
+		return nil
+	}
+
+	var decoded interface{}
+	if err := json.Unmarshal(reply, &decoded); err != nil {
+		t.Fatalf("Invalid reply %s: %v", reply, err)
+	}
+	return decoded
+}
+
+func jsonRPCErrorCode(reply interface{}) int {
+	var fields, _ = reply.(map[string]interface{})
+	var rpcError, _ = fields["error"].(map[string]interface{})
+	code, _ := rpcError["code"].(float64)
+	return int(code)
+}
+
+func TestJSONRPCCall(t *testing.T) {
+	for _, body := range []string{
+		`{"jsonrpc": "2.0", "method": "test.echo", "params": {"text": "hi"}, "id": 7}`,
+		`{"jsonrpc": "2.0", "method": "test.echo", "params": ["hi"], "id": 7}`,
+	} {
+		var reply = jsonRPC(t, body).(map[string]interface{})
+		var result, _ = reply["result"].(map[string]interface{})
+		if reply["id"] != float64(7) || result["text"] != "hi" {
+			t.Errorf("%s: wrong reply %v", body, reply)
+		}
+	}
+}
+
+func TestJSONRPCErrors(t *testing.T) {
+	var cases = []struct {
+		body string
+		code int
+	}{
+		{`{"jsonrpc": "2.0", "method": "test.echo", "params": {"text": `, JSONRPCParseError},
+		{`{"jsonrpc": "1.0", "method": "test.echo", "id": 1}`, JSONRPCInvalidRequest},
+		{`"test.echo"`, JSONRPCInvalidRequest},
+		{`[]`, JSONRPCInvalidRequest},
+		{`{"jsonrpc": "2.0", "method": "test.nope", "id": 1}`, JSONRPCMethodNotFound},
+		{`{"jsonrpc": "2.0", "method": "echo", "id": 1}`, JSONRPCMethodNotFound},
+		{`{"jsonrpc": "2.0", "method": "test.echo", "params": {}, "id": 1}`, JSONRPCInvalidParams},
+		{`{"jsonrpc": "2.0", "method": "test.echo", "params": ["a", "b"], "id": 1}`, JSONRPCInvalidParams},
+		{`{"jsonrpc": "2.0", "method": "test.fail", "id": 1}`, JSONRPCCallFailed},
+		{`{"jsonrpc": "2.0", "method": "test.whoami", "id": 1}`, JSONRPCUnauthorized},
+	}
+
+	for _, c := range cases {
+		if code := jsonRPCErrorCode(jsonRPC(t, c.body)); code != c.code {
+			t.Errorf("%s: expected %d, got %d", c.body, c.code, code)
+		}
+	}
+}
+
+func TestJSONRPCBatch(t *testing.T) {
+	var reply = jsonRPC(t, `[
+		{"jsonrpc": "2.0", "method": "test.echo", "params": {"text": "a"}, "id": 1},
+		{"jsonrpc": "2.0", "method": "test.echo", "params": {"text": "b"}},
+		{"jsonrpc": "2.0", "method": "test.nope", "id": 2}
+	]`)
+
+	var replies, _ = reply.([]interface{})
+	if len(replies) != 2 {
+		t.Fatalf("Expected replies for the two calls with ids, got %v", reply)
+	}
+	if jsonRPCErrorCode(replies[1]) != JSONRPCMethodNotFound {
+		t.Errorf("Wrong second reply: %v", replies[1])
+	}
+
+	var notification = `{"jsonrpc": "2.0", "method": "test.echo", "params": {"text": "a"}}`
+	if reply := jsonRPC(t, notification); reply != nil {
+		t.Errorf("Notification answered: %v", reply)
+	}
+	if reply := jsonRPC(t, "[" + notification + "]"); reply != nil {
+		t.Errorf("Batch of notifications answered: %v", reply)
+	}
+}
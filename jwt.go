@@ -0,0 +1,454 @@
+package goservice
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+type JWTOptions struct {
+	// Secret verifies HS256/384/512 tokens.
+	Secret []byte
+
+	// Keys verify RS* and ES* tokens, by "kid". They may be
+	// *rsa.PublicKey or *ecdsa.PublicKey. JWKSFile adds the keys of a
+	// local JSON Web Key Set file.
+	Keys map[string]crypto.PublicKey
+	JWKSFile string
+
+	// Issuer and Audience, if set, must match the iss and aud claims.
+	Issuer string
+	Audience string
+
+	// Leeway allows for clock skew when checking exp and nbf.
+	Leeway time.Duration
+
+	// AllowNoExpiry accepts tokens without an exp claim, which are
+	// otherwise rejected since they'd be good forever.
+	AllowNoExpiry bool
+
+	// ClaimsUser maps verified claims to a User. The default makes a
+	// BasicUser from "sub", "name" and "roles".
+	ClaimsUser func(APIData) (User, error)
+}
+
+// JWTValidator checks bearer JWTs, for use as an HttpRpcEndpoint
+// SessionResolver (see Resolver) or a WebsocketEndpoint Handshake.
+type JWTValidator struct {
+	options JWTOptions
+	keys map[string]crypto.PublicKey
+	sync.RWMutex
+}
+
+var (
+	errBadJWT = errors.New("malformed token")
+	errJWTSignature = errors.New("invalid token signature")
+)
+
+func NewJWTValidator(options JWTOptions) (*JWTValidator, error) {
+	if options.ClaimsUser == nil {
+		options.ClaimsUser = DefaultClaimsUser
+	}
+
+	var validator = &JWTValidator{
+		options: options,
+	}
+	if err := validator.ReloadKeys(); err != nil {
+		return nil, err
+	}
+	return validator, nil
+}
+
+// ReloadKeys rereads the JWKS file, e.g. after the SSO rotates keys.
+func (validator *JWTValidator) ReloadKeys() error {
+	var keys = make(map[string]crypto.PublicKey)
+	for kid, key := range validator.options.Keys {
+		keys[kid] = key
+	}
+
+	if validator.options.JWKSFile != "" {
+		data, err := ioutil.ReadFile(validator.options.JWKSFile)
+		if err != nil {
+			return err
+		}
+		fileKeys, err := ParseJWKS(data)
+		if err != nil {
+			return err
+		}
+		for kid, key := range fileKeys {
+			keys[kid] = key
+		}
+	}
+
+	validator.Lock()
+	validator.keys = keys
+	validator.Unlock()
+	return nil
+}
+
+// Resolver wraps next so a valid bearer JWT logs the session in. An
+// invalid one rejects the request. The Authorization header is
+// removed once checked, so a SessionTracker behind it falls back to
+// its cookie.
+func (validator *JWTValidator) Resolver(next SessionResolver) SessionResolver {
+	return func(req *http.Request, response http.ResponseWriter, endpoint *HttpRpcEndpoint) (Session, error) {
+		user, err := validator.tokenUser(bearerToken(req))
+		if err != nil {
+			return nil, err
+		}
+		if user != nil {
+			req.Header.Del("Authorization")
+		}
+
+		session, err := next(req, response, endpoint)
+		if err != nil || user == nil {
+			return session, err
+		}
+
+		if current := session.User(); current == nil || current.ID() != user.ID() {
+			session.SetUser(user)
+		}
+		return session, nil
+	}
+}
+
+// Handshake returns the User for a websocket request's bearer JWT, or
+// nil if it has none. Websockets opened by browsers can't set
+// headers, so an access_token query parameter is accepted too; plain
+// HTTP calls must use the header, keeping tokens out of URLs.
+func (validator *JWTValidator) Handshake(req *http.Request) (User, error) {
+	var token = bearerToken(req)
+	if token == "" {
+		token = req.URL.Query().Get("access_token")
+	}
+	return validator.tokenUser(token)
+}
+
+func (validator *JWTValidator) tokenUser(token string) (User, error) {
+	if token == "" {
+		return nil, nil
+	}
+
+	claims, err := validator.Validate(token)
+	if err != nil {
+		return nil, err
+	}
+	return validator.options.ClaimsUser(claims)
+}
+
+func bearerToken(req *http.Request) string {
+	var auth = req.Header.Get("Authorization")
+	if strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimSpace(auth[len("Bearer "):])
+	}
+	return ""
+}
+
+// Validate checks a token's signature and registered claims and
+// returns its claims.
+func (validator *JWTValidator) Validate(token string) (APIData, error) {
+	var parts = strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errBadJWT
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, errBadJWT
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errBadJWT
+	}
+
+	if err := validator.verify(header.Alg, header.Kid, parts[0] + "." + parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]interface{}
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, errBadJWT
+	}
+
+	if err := validator.checkClaims(claims); err != nil {
+		return nil, err
+	}
+	return ToAPIData(claims).(APIData), nil
+}
+
+func (validator *JWTValidator) verify(alg string, kid string, signed string, signature []byte) error {
+	if len(alg) != 5 {
+		return fmt.Errorf("unsupported token algorithm: %q", alg)
+	}
+
+	var algHash crypto.Hash
+	switch alg[2:] {
+	case "256":
+		algHash = crypto.SHA256
+	case "384":
+		algHash = crypto.SHA384
+	case "512":
+		algHash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported token algorithm: %q", alg)
+	}
+
+	if alg[:2] == "HS" {
+		if len(validator.options.Secret) == 0 {
+			return errJWTSignature
+		}
+		var mac = hmac.New(hashFunc(algHash), validator.options.Secret)
+		mac.Write([]byte(signed))
+		if subtle.ConstantTimeCompare(mac.Sum(nil), signature) != 1 {
+			return errJWTSignature
+		}
+		return nil
+	}
+
+	var digest = hashFunc(algHash)()
+	digest.Write([]byte(signed))
+	var sum = digest.Sum(nil)
+
+	for _, key := range validator.candidateKeys(kid) {
+		switch key := key.(type) {
+		case *rsa.PublicKey:
+			if alg[:2] == "RS" && rsa.VerifyPKCS1v15(key, algHash, sum, signature) == nil {
+				return nil
+			}
+		case *ecdsa.PublicKey:
+			if alg[:2] == "ES" && verifyECDSA(key, sum, signature) {
+				return nil
+			}
+		}
+	}
+	return errJWTSignature
+}
+
+func (validator *JWTValidator) candidateKeys(kid string) []crypto.PublicKey {
+	validator.RLock()
+	defer validator.RUnlock()
+
+	if kid != "" {
+		if key, ok := validator.keys[kid]; ok {
+			return []crypto.PublicKey{key}
+		}
+		return nil
+	}
+
+	var keys []crypto.PublicKey
+	for _, key := range validator.keys {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func (validator *JWTValidator) checkClaims(claims map[string]interface{}) error {
+	var now = time.Now()
+	var leeway = validator.options.Leeway
+
+	if rawExp, given := claims["exp"]; given {
+		exp, ok := rawExp.(float64)
+		if !ok {
+			return errors.New("invalid token expiry")
+		}
+		if now.After(time.Unix(int64(exp), 0).Add(leeway)) {
+			return errors.New("token expired")
+		}
+	} else if !validator.options.AllowNoExpiry {
+		return errors.New("token has no expiry")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok {
+		if now.Add(leeway).Before(time.Unix(int64(nbf), 0)) {
+			return errors.New("token not yet valid")
+		}
+	}
+
+	if validator.options.Issuer != "" && claims["iss"] != validator.options.Issuer {
+		return errors.New("wrong token issuer")
+	}
+
+	if validator.options.Audience != "" && !hasAudience(claims["aud"], validator.options.Audience) {
+		return errors.New("wrong token audience")
+	}
+	return nil
+}
+
+func hasAudience(aud interface{}, audience string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == audience
+	case []interface{}:
+		for _, a := range aud {
+			if a == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// DefaultClaimsUser makes a BasicUser from the "sub", "name" and
+// "roles" claims.
+func DefaultClaimsUser(claims APIData) (User, error) {
+	sub, _ := claims["sub"].(string)
+	if sub == "" {
+		return nil, errors.New("token has no subject")
+	}
+
+	name, _ := claims["name"].(string)
+	if name == "" {
+		name = sub
+	}
+
+	var roles []string
+	if list, ok := claims["roles"].([]interface{}); ok {
+		for _, role := range list {
+			if role, ok := role.(string); ok {
+				roles = append(roles, role)
+			}
+		}
+	}
+
+	return NewBasicUser(sub, name, roles...), nil
+}
+
+
+// ------------------------------------------
+// Keys
+// ------------------------------------------
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N string `json:"n"`
+	E string `json:"e"`
+	Crv string `json:"crv"`
+	X string `json:"x"`
+	Y string `json:"y"`
+}
+
+// ParseJWKS reads the RSA and EC public keys of a JSON Web Key Set,
+// keyed by kid. Keys for other uses than signing are skipped.
+func ParseJWKS(data []byte) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+
+	var keys = make(map[string]crypto.PublicKey)
+	for i, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		key, err := jwk.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("JWKS key %d: %v", i, err)
+		}
+
+		var kid = jwk.Kid
+		if kid == "" {
+			kid = fmt.Sprintf("%d", i)
+		}
+		keys[kid] = key
+	}
+	return keys, nil
+}
+
+func (jwk jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", jwk.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{
+			Curve: curve,
+			X: new(big.Int).SetBytes(x),
+			Y: new(big.Int).SetBytes(y),
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", jwk.Kty)
+}
+
+// verifyECDSA checks a JWS ECDSA signature: r and s concatenated, each
+// padded to the curve's size.
+func verifyECDSA(key *ecdsa.PublicKey, sum []byte, signature []byte) bool {
+	var size = (key.Curve.Params().BitSize + 7) / 8
+	if len(signature) != 2 * size {
+		return false
+	}
+	var r = new(big.Int).SetBytes(signature[:size])
+	var s = new(big.Int).SetBytes(signature[size:])
+	return ecdsa.Verify(key, sum, r, s)
+}
+
+func hashFunc(algHash crypto.Hash) func() hash.Hash {
+	switch algHash {
+	case crypto.SHA384:
+		return sha512.New384
+	case crypto.SHA512:
+		return sha512.New
+	}
+	return sha256.New
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,217 @@
+package goservice
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testJWTSecret = []byte("sekrit")
+
+func encodeJWTPart(t *testing.T, part interface{}) string {
+	encoded, err := json.Marshal(part)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(encoded)
+}
+
+func signHS256(t *testing.T, header APIData, claims APIData, secret []byte) string {
+	var signed = encodeJWTPart(t, header) + "." + encodeJWTPart(t, claims)
+	var mac = hmac.New(sha256.New, secret)
+	mac.Write([]byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func signRS256(t *testing.T, claims APIData, key *rsa.PrivateKey) string {
+	var signed = encodeJWTPart(t, APIData{"alg": "RS256", "kid": "k1"}) + "." + encodeJWTPart(t, claims)
+	var sum = sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func newTestValidator(t *testing.T, options JWTOptions) *JWTValidator {
+	validator, err := NewJWTValidator(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return validator
+}
+
+func expiresIn(d time.Duration) float64 {
+	return float64(time.Now().Add(d).Unix())
+}
+
+func TestJWTValidate(t *testing.T) {
+	var validator = newTestValidator(t, JWTOptions{Secret: testJWTSecret})
+	var header = APIData{"alg": "HS256", "typ": "JWT"}
+
+	claims, err := validator.Validate(signHS256(t, header, APIData{"sub": "u1", "exp": expiresIn(time.Hour)}, testJWTSecret))
+	if err != nil {
+		t.Fatalf("Valid token rejected: %v", err)
+	}
+	if claims["sub"] != "u1" {
+		t.Errorf("Wrong claims: %v", claims)
+	}
+
+	user, err := DefaultClaimsUser(claims)
+	if err != nil || user.ID() != "u1" {
+		t.Errorf("Wrong user: %v, %v", user, err)
+	}
+}
+
+func TestJWTBadSignature(t *testing.T) {
+	var validator = newTestValidator(t, JWTOptions{Secret: testJWTSecret})
+	var header = APIData{"alg": "HS256"}
+	var claims = APIData{"sub": "u1", "exp": expiresIn(time.Hour)}
+
+	if _, err := validator.Validate(signHS256(t, header, claims, []byte("guess"))); err != errJWTSignature {
+		t.Errorf("Wrong secret: expected %v, got %v", errJWTSignature, err)
+	}
+
+	// Claims changed after signing
+	var token = signHS256(t, header, claims, testJWTSecret)
+	var parts = strings.Split(token, ".")
+	parts[1] = encodeJWTPart(t, APIData{"sub": "admin", "exp": expiresIn(time.Hour)})
+	if _, err := validator.Validate(strings.Join(parts, ".")); err != errJWTSignature {
+		t.Errorf("Tampered claims: expected %v, got %v", errJWTSignature, err)
+	}
+
+	for _, bad := range []string{"", "a.b", "a.b.c.d", "!!.!!.!!"} {
+		if _, err := validator.Validate(bad); err != errBadJWT {
+			t.Errorf("%q: expected %v, got %v", bad, errBadJWT, err)
+		}
+	}
+}
+
+func TestJWTAlgorithmMismatch(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var validator = newTestValidator(t, JWTOptions{
+		Keys: map[string]crypto.PublicKey{"k1": &key.PublicKey},
+	})
+	var claims = APIData{"sub": "u1", "exp": expiresIn(time.Hour)}
+
+	if _, err := validator.Validate(signRS256(t, claims, key)); err != nil {
+		t.Fatalf("Valid RS256 token rejected: %v", err)
+	}
+
+	// An HMAC signed with the public key, which the attacker has,
+	// mustn't pass for RS256.
+	publicDER, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	var forged = signHS256(t, APIData{"alg": "HS256", "kid": "k1"}, claims, publicDER)
+	if _, err := validator.Validate(forged); err != errJWTSignature {
+		t.Errorf("HS256 with public key: expected %v, got %v", errJWTSignature, err)
+	}
+
+	var relabelled = strings.Split(signRS256(t, claims, key), ".")
+	relabelled[0] = encodeJWTPart(t, APIData{"alg": "ES256", "kid": "k1"})
+	if _, err := validator.Validate(strings.Join(relabelled, ".")); err != errJWTSignature {
+		t.Errorf("RS256 relabelled ES256: expected %v, got %v", errJWTSignature, err)
+	}
+
+	var unsigned = encodeJWTPart(t, APIData{"alg": "none"}) + "." + encodeJWTPart(t, claims) + "."
+	if _, err := validator.Validate(unsigned); err == nil {
+		t.Errorf("Unsigned token accepted")
+	}
+}
+
+func TestJWTExpiry(t *testing.T) {
+	var header = APIData{"alg": "HS256"}
+	var validator = newTestValidator(t, JWTOptions{Secret: testJWTSecret})
+
+	var cases = []struct {
+		claims APIData
+		valid bool
+	}{
+		{APIData{"sub": "u1", "exp": expiresIn(time.Hour)}, true},
+		{APIData{"sub": "u1", "exp": expiresIn(-time.Hour)}, false},
+		{APIData{"sub": "u1", "exp": "tomorrow"}, false},
+		{APIData{"sub": "u1"}, false},
+		{APIData{"sub": "u1", "exp": expiresIn(time.Hour), "nbf": expiresIn(time.Hour)}, false},
+	}
+	for i, c := range cases {
+		_, err := validator.Validate(signHS256(t, header, c.claims, testJWTSecret))
+		if (err == nil) != c.valid {
+			t.Errorf("Case %d: expected valid=%v, got %v", i, c.valid, err)
+		}
+	}
+
+	var lenient = newTestValidator(t, JWTOptions{
+		Secret: testJWTSecret,
+		Leeway: 2 * time.Minute,
+		AllowNoExpiry: true,
+	})
+	if _, err := lenient.Validate(signHS256(t, header, APIData{"sub": "u1"}, testJWTSecret)); err != nil {
+		t.Errorf("AllowNoExpiry: %v", err)
+	}
+	var justExpired = APIData{"sub": "u1", "exp": expiresIn(-time.Minute)}
+	if _, err := lenient.Validate(signHS256(t, header, justExpired, testJWTSecret)); err != nil {
+		t.Errorf("Leeway: %v", err)
+	}
+}
+
+func TestJWTIssuerAudience(t *testing.T) {
+	var header = APIData{"alg": "HS256"}
+	var validator = newTestValidator(t, JWTOptions{
+		Secret: testJWTSecret,
+		Issuer: "sso",
+		Audience: "app",
+	})
+
+	var claims = APIData{"sub": "u1", "exp": expiresIn(time.Hour), "iss": "sso", "aud": []interface{}{"other", "app"}}
+	if _, err := validator.Validate(signHS256(t, header, claims, testJWTSecret)); err != nil {
+		t.Errorf("Valid token rejected: %v", err)
+	}
+
+	claims["aud"] = "other"
+	if _, err := validator.Validate(signHS256(t, header, claims, testJWTSecret)); err == nil {
+		t.Errorf("Wrong audience accepted")
+	}
+
+	claims["aud"], claims["iss"] = "app", "elsewhere"
+	if _, err := validator.Validate(signHS256(t, header, claims, testJWTSecret)); err == nil {
+		t.Errorf("Wrong issuer accepted")
+	}
+}
+
+// Tokens in URLs end up in logs and Referer headers, so only the
+// websocket handshake, which can't use the header, accepts them.
+func TestJWTQueryToken(t *testing.T) {
+	var validator = newTestValidator(t, JWTOptions{Secret: testJWTSecret})
+	var token = signHS256(t, APIData{"alg": "HS256"}, APIData{"sub": "u1", "exp": expiresIn(time.Hour)}, testJWTSecret)
+
+	var inQuery = httptest.NewRequest("GET", "/test/whoami?access_token=" + token, nil)
+	if user, err := validator.Handshake(inQuery); err != nil || user == nil || user.ID() != "u1" {
+		t.Errorf("Handshake ignored the query token: %v, %v", user, err)
+	}
+
+	var endpoint = testHttpEndpoint()
+	var resolver = validator.Resolver(DefaultSessionResolver)
+
+	session, err := resolver(inQuery, httptest.NewRecorder(), endpoint)
+	if err != nil || session.User() != nil {
+		t.Errorf("Resolver accepted the query token: %v, %v", session.User(), err)
+	}
+
+	var inHeader = httptest.NewRequest("GET", "/test/whoami", nil)
+	inHeader.Header.Set("Authorization", "Bearer " + token)
+	session, err = resolver(inHeader, httptest.NewRecorder(), endpoint)
+	if err != nil || session.User() == nil || session.User().ID() != "u1" {
+		t.Errorf("Resolver ignored the header token: %v", err)
+	}
+}
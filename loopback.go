@@ -0,0 +1,192 @@
+package goservice
+
+import (
+	"io/ioutil"
+	"log"
+	"sync"
+)
+
+// LoopbackEndpoint is an in-memory Endpoint. Requests are handed
+// straight to the server's API, so nothing touches the network.
+type LoopbackEndpoint struct {
+	context ServerContext
+}
+
+func NewLoopbackEndpoint(context ServerContext) *LoopbackEndpoint {
+	return &LoopbackEndpoint{
+		context: context,
+	}
+}
+
+func (endpoint *LoopbackEndpoint) Start() bool {
+	return true
+}
+
+func (endpoint *LoopbackEndpoint) Stop() bool {
+	return true
+}
+
+func (endpoint *LoopbackEndpoint) Context() ServerContext {
+	return endpoint.context
+}
+
+// Connect creates a session whose sends are captured by the returned
+// connection.
+func (endpoint *LoopbackEndpoint) Connect() (Session, *LoopbackConnection) {
+	var conn = &LoopbackConnection{}
+	return endpoint.context.CreateSession(conn), conn
+}
+
+// Join adds another captured connection to a live session.
+func (endpoint *LoopbackEndpoint) Join(session Session) (*LoopbackConnection, bool) {
+	var conn = &LoopbackConnection{}
+	if !endpoint.context.JoinSession(session, conn) {
+		return nil, false
+	}
+	return conn, true
+}
+
+// Drop tells the server one of the session's connections has closed.
+func (endpoint *LoopbackEndpoint) Drop(session Session, conn *LoopbackConnection, reason string) {
+	conn.Close()
+	endpoint.context.ConnectionClosed(session, conn, reason)
+}
+
+// Disconnect tells the server the session's connection has closed.
+func (endpoint *LoopbackEndpoint) Disconnect(session Session, reason string) {
+	endpoint.context.SessionClosed(session, reason)
+}
+
+func (endpoint *LoopbackEndpoint) HandleRequest(request APIData, session Session) APIData {
+	return endpoint.context.API().HandleRequest(request, session, endpoint.context)
+}
+
+func (endpoint *LoopbackEndpoint) HandleCall(
+	serviceName string, methodName string,
+	data APIData, session Session) (bool, []string, APIData) {
+	return endpoint.context.API().HandleCall(
+		serviceName, methodName, data, session, endpoint.context)
+}
+
+
+type LoopbackConnection struct {
+	sent [][]byte
+	pushed []APIData
+	closed bool
+	sync.Mutex
+}
+
+func (conn *LoopbackConnection) Send(msg []byte) {
+	var copied = make([]byte, len(msg))
+	copy(copied, msg)
+
+	conn.Lock()
+	conn.sent = append(conn.sent, copied)
+	conn.Unlock()
+}
+
+func (conn *LoopbackConnection) Push(data APIData) {
+	conn.Lock()
+	conn.pushed = append(conn.pushed, data)
+	conn.Unlock()
+}
+
+func (conn *LoopbackConnection) Close() {
+	conn.Lock()
+	conn.closed = true
+	conn.Unlock()
+}
+
+func (conn *LoopbackConnection) Info() ConnectionInfo {
+	return ConnectionInfo{
+		Endpoint: "loopback",
+		RemoteAddr: "loopback",
+	}
+}
+
+func (conn *LoopbackConnection) Closed() bool {
+	conn.Lock()
+	defer conn.Unlock()
+	return conn.closed
+}
+
+// Sent returns every message sent so far.
+func (conn *LoopbackConnection) Sent() [][]byte {
+	conn.Lock()
+	defer conn.Unlock()
+	return append([][]byte(nil), conn.sent...)
+}
+
+// Take returns every message sent so far and forgets them.
+func (conn *LoopbackConnection) Take() [][]byte {
+	conn.Lock()
+	defer conn.Unlock()
+	var sent = conn.sent
+	conn.sent = nil
+	return sent
+}
+
+// Pushed returns every message pushed so far, unencoded.
+func (conn *LoopbackConnection) Pushed() []APIData {
+	conn.Lock()
+	defer conn.Unlock()
+	return append([]APIData(nil), conn.pushed...)
+}
+
+// TakePushed returns every message pushed so far and forgets them.
+func (conn *LoopbackConnection) TakePushed() []APIData {
+	conn.Lock()
+	defer conn.Unlock()
+	var pushed = conn.pushed
+	conn.pushed = nil
+	return pushed
+}
+
+
+// ------------------------------------------
+// Test harness
+// ------------------------------------------
+
+// Harness wires a Server to a LoopbackEndpoint so handlers can be
+// exercised through the same HandleRequest / HandleCall path the real
+// endpoints use.
+type Harness struct {
+	Server *Server
+	Endpoint *LoopbackEndpoint
+}
+
+// NewHarness builds a quiet Server around api. A nil creator means
+// BasicSessionCreator.
+func NewHarness(api API, creator SessionCreator) *Harness {
+	if creator == nil {
+		creator = BasicSessionCreator
+	}
+
+	var logger = log.New(ioutil.Discard, "", 0)
+	var server = NewServer(api, creator, logger)
+	var endpoint = NewLoopbackEndpoint(server)
+	server.AddEndpoint(endpoint)
+
+	return &Harness{
+		Server: server,
+		Endpoint: endpoint,
+	}
+}
+
+func (harness *Harness) Connect() (Session, *LoopbackConnection) {
+	return harness.Endpoint.Connect()
+}
+
+// Call wraps the arguments in a request envelope and returns the
+// response envelope, as a websocket client would see it.
+func (harness *Harness) Call(session Session, serviceName string, methodName string, data APIData) APIData {
+	if data == nil {
+		data = make(APIData)
+	}
+
+	return harness.Endpoint.HandleRequest(APIData{
+		"service": serviceName,
+		"method": methodName,
+		"data": data,
+	}, session)
+}
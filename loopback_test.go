@@ -0,0 +1,76 @@
+package goservice
+
+import (
+	"testing"
+)
+
+func testAPI() API {
+	var svc = NewService("test")
+	svc.AddMethod("echo", []APIArg{
+		APIArg{Name: "text", ArgType: StringArg},
+	}, func(args APIData, session Session, context ServerContext) (bool, APIData) {
+		return true, APIData{"text": args["text"]}
+	})
+	svc.AddMethod("fail", nil, func(args APIData, session Session, context ServerContext) (bool, APIData) {
+		return false, APIData{"why": "asked to"}
+	})
+	svc.AddMethod("panic", nil, func(args APIData, session Session, context ServerContext) (bool, APIData) {
+		panic("boom")
+	})
+	svc.AddMethod("whoami", nil, func(args APIData, session Session, context ServerContext) (bool, APIData) {
+		return true, APIData{"id": session.User().ID()}
+	})
+	svc.Restrict("whoami")
+	svc.AddMethod("admin", nil, func(args APIData, session Session, context ServerContext) (bool, APIData) {
+		return true, nil
+	})
+	svc.Restrict("admin", "admin")
+
+	var api = NewServiceCollection()
+	api.AddService(svc)
+	return api
+}
+
+func expectReason(t *testing.T, response APIData, reason string) {
+	if success, _ := response["success"].(bool); success {
+		t.Fatalf("Expected %s, got success: %v", reason, response)
+	}
+	if response["reason"] != reason {
+		t.Fatalf("Expected %s, got %v", reason, response)
+	}
+}
+
+func TestHarnessCall(t *testing.T) {
+	var harness = NewHarness(testAPI(), nil)
+	session, _ := harness.Connect()
+
+	var response = harness.Call(session, "test", "echo", APIData{"text": "hi"})
+	if success, _ := response["success"].(bool); !success {
+		t.Fatalf("Call failed: %v", response)
+	}
+	if data := response["data"].(APIData); data["text"] != "hi" {
+		t.Errorf("Wrong data: %v", data)
+	}
+
+	expectReason(t, harness.Call(session, "test", "echo", nil), "call error")
+	expectReason(t, harness.Call(session, "test", "nope", nil), "call error")
+	expectReason(t, harness.Call(session, "test", "fail", nil), "failure")
+}
+
+func TestHarnessPush(t *testing.T) {
+	var harness = NewHarness(testAPI(), nil)
+	session, conn := harness.Connect()
+
+	harness.Server.Subscribe("news", session)
+	if count := harness.Server.Publish("news", APIData{"n": 1}); count != 1 {
+		t.Fatalf("Published to %d sessions", count)
+	}
+
+	var pushed = conn.TakePushed()
+	if len(pushed) != 1 || pushed[0]["topic"] != "news" {
+		t.Fatalf("Wrong pushes: %v", pushed)
+	}
+	if len(conn.Pushed()) != 0 {
+		t.Errorf("TakePushed kept pushes")
+	}
+}
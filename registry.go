@@ -0,0 +1,125 @@
+package goservice
+
+import (
+	"sync"
+)
+
+// SessionRegistry tracks every session from CreateSession until its
+// connection closes.
+type SessionRegistry struct {
+	sessions map[string]Session
+	sync.RWMutex
+}
+
+func NewSessionRegistry() *SessionRegistry {
+	return &SessionRegistry{
+		sessions: make(map[string]Session),
+	}
+}
+
+func (registry *SessionRegistry) add(session Session) {
+	registry.Lock()
+	registry.sessions[session.ID()] = session
+	registry.Unlock()
+}
+
+// remove reports whether the session was still registered.
+func (registry *SessionRegistry) remove(session Session) bool {
+	registry.Lock()
+	defer registry.Unlock()
+
+	if current, ok := registry.sessions[session.ID()]; !ok || current != session {
+		return false
+	}
+	delete(registry.sessions, session.ID())
+	return true
+}
+
+func (registry *SessionRegistry) Find(id string) Session {
+	registry.RLock()
+	defer registry.RUnlock()
+	return registry.sessions[id]
+}
+
+// ForUser returns every session logged in as the given User.ID().
+func (registry *SessionRegistry) ForUser(userID string) []Session {
+	var sessions []Session
+	registry.Each(func(session Session) {
+		if user := session.User(); user != nil && user.ID() == userID {
+			sessions = append(sessions, session)
+		}
+	})
+	return sessions
+}
+
+func (registry *SessionRegistry) All() []Session {
+	var sessions []Session
+	registry.Each(func(session Session) {
+		sessions = append(sessions, session)
+	})
+	return sessions
+}
+
+// Each calls fn for every session. The registry is read-locked while
+// it runs, so fn must not create or close sessions.
+func (registry *SessionRegistry) Each(fn func(Session)) {
+	registry.RLock()
+	defer registry.RUnlock()
+	for _, session := range registry.sessions {
+		fn(session)
+	}
+}
+
+func (registry *SessionRegistry) Count() int {
+	registry.RLock()
+	defer registry.RUnlock()
+	return len(registry.sessions)
+}
+
+// UserCount returns the number of distinct logged-in users.
+func (registry *SessionRegistry) UserCount() int {
+	var users = make(map[string]bool)
+	registry.Each(func(session Session) {
+		if user := session.User(); user != nil {
+			users[user.ID()] = true
+		}
+	})
+	return len(users)
+}
+
+func (registry *SessionRegistry) SendToUser(userID string, msg []byte) int {
+	var sessions = registry.ForUser(userID)
+	for _, session := range sessions {
+		session.Send(msg)
+	}
+	return len(sessions)
+}
+
+func (registry *SessionRegistry) PushToUser(userID string, data APIData) int {
+	var sessions = registry.ForUser(userID)
+	for _, session := range sessions {
+		session.Push(data)
+	}
+	return len(sessions)
+}
+
+
+// ------------------------------------------
+// Context API
+// ------------------------------------------
+
+func (server *Server) Sessions() *SessionRegistry {
+	return server.sessions
+}
+
+// Kick closes a session's connection and forgets the session.
+func (server *Server) Kick(id string) bool {
+	var session = server.sessions.Find(id)
+	if session == nil {
+		return false
+	}
+
+	server.SessionClosed(session, "kicked")
+	session.Close()
+	return true
+}
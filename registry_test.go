@@ -0,0 +1,62 @@
+package goservice
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestSessionRegistry(t *testing.T) {
+	var harness = NewHarness(testAPI(), nil)
+	var registry = harness.Server.Sessions()
+
+	first, firstConn := harness.Connect()
+	second, _ := harness.Connect()
+	harness.Connect()
+	first.SetUser(NewBasicUser("u1", "One"))
+	second.SetUser(NewBasicUser("u1", "One"))
+
+	if registry.Count() != 3 || registry.UserCount() != 1 {
+		t.Errorf("Wrong counts: %d sessions, %d users", registry.Count(), registry.UserCount())
+	}
+	if registry.Find(first.ID()) != first {
+		t.Errorf("Session not found")
+	}
+	if sessions := registry.ForUser("u1"); len(sessions) != 2 {
+		t.Errorf("Wrong sessions for user: %v", sessions)
+	}
+	if count := registry.PushToUser("u1", APIData{"hello": true}); count != 2 || len(firstConn.Pushed()) != 1 {
+		t.Errorf("Pushed to %d sessions", count)
+	}
+
+	if !harness.Server.Kick(first.ID()) || registry.Find(first.ID()) != nil {
+		t.Errorf("Kicked session still registered")
+	}
+	if harness.Server.Kick(first.ID()) {
+		t.Errorf("Kicked a session twice")
+	}
+}
+
+// Logins race with lookups from other goroutines; run with -race.
+func TestSessionUserConcurrency(t *testing.T) {
+	var harness = NewHarness(testAPI(), nil)
+	var registry = harness.Server.Sessions()
+	session, _ := harness.Connect()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			session.SetUser(NewBasicUser("u1", "One"))
+			session.SetUser(nil)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			registry.ForUser("u1")
+			registry.UserCount()
+		}
+	}()
+	wg.Wait()
+}
@@ -0,0 +1,290 @@
+package goservice
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ugorji/go-msgpack"
+	"code.google.com/p/go.net/websocket"
+)
+
+// A resumable websocket session is announced to the client with an
+// 's'-prefixed msgpack frame, {"session": id, "resume": token}. A
+// client reconnecting with ?resume=<token> within the endpoint's
+// ResumeGrace gets the same Session back, along with anything sent to
+// it in the meantime.
+//
+// Tokens start with the session's ID. With the endpoint's Store set,
+// a token the endpoint doesn't know, e.g. after a restart, restores
+// its session from the store if the record lists the token.
+//
+// A client connecting with ?join=<token> instead gets a connection of
+// its own on that token's session, with its own resume token; this is
+// how several tabs share a session.
+type resumableSession struct {
+	session Session
+	conn *WebsocketSessionConnection
+	token string
+	timer *time.Timer
+}
+
+func (endpoint *WebsocketEndpoint) attachSession(ws *websocket.Conn) (Session, *WebsocketSessionConnection) {
+	if token := ws.Request().URL.Query().Get("resume"); token != "" {
+		if resumed := endpoint.resume(token, ws); resumed != nil {
+			fmt.Printf("Session resumed: %s\n", resumed.session.ID())
+			sendSessionFrame(resumed.conn, resumed.session, token)
+			return resumed.session, resumed.conn
+		}
+		if restored := endpoint.restore(token, ws); restored != nil {
+			fmt.Printf("Session restored: %s\n", restored.session.ID())
+			sendSessionFrame(restored.conn, restored.session, token)
+			return restored.session, restored.conn
+		}
+	}
+
+	var sessConn = newWebsocketSessionConnection(ws)
+
+	if session := endpoint.joinable(ws); session != nil {
+		if endpoint.context.JoinSession(session, sessConn) {
+			fmt.Printf("Session joined: %s\n", session.ID())
+			endpoint.makeResumable(session, sessConn)
+			return session, sessConn
+		}
+	}
+
+	var session = endpoint.context.CreateSession(sessConn)
+	fmt.Printf("New session: %s\n", session.ID())
+	endpoint.makeResumable(session, sessConn)
+	return session, sessConn
+}
+
+// joinable finds the session named by ?join=<token>, falling back to
+// the endpoint's Join function for connections from allowed origins.
+func (endpoint *WebsocketEndpoint) joinable(ws *websocket.Conn) Session {
+	if token := ws.Request().URL.Query().Get("join"); token != "" {
+		endpoint.resumeLock.Lock()
+		var existing = endpoint.resumable[token]
+		endpoint.resumeLock.Unlock()
+
+		if existing != nil {
+			return existing.session
+		}
+	}
+
+	if endpoint.Join == nil {
+		return nil
+	}
+	if !endpoint.allowedOrigin(ws.Request()) {
+		fmt.Printf("Not joining session from origin %q\n", ws.Request().Header.Get("Origin"))
+		return nil
+	}
+	return endpoint.Join(ws.Request())
+}
+
+func (endpoint *WebsocketEndpoint) allowedOrigin(req *http.Request) bool {
+	var origin = req.Header.Get("Origin")
+	if origin == "" {
+		return false
+	}
+	if len(endpoint.Origins) > 0 {
+		return hasString(endpoint.Origins, origin)
+	}
+
+	parsed, err := url.Parse(origin)
+	return err == nil && parsed.Host == req.Host
+}
+
+func (endpoint *WebsocketEndpoint) makeResumable(session Session, sessConn *WebsocketSessionConnection) {
+	if endpoint.ResumeGrace <= 0 {
+		return
+	}
+
+	var token = session.ID() + "." + randomToken()
+	sessConn.resumeToken = token
+	endpoint.resumeLock.Lock()
+	endpoint.resumable[token] = &resumableSession{
+		session: session,
+		conn: sessConn,
+		token: token,
+	}
+	endpoint.resumeLock.Unlock()
+	endpoint.saveSession(session)
+	sendSessionFrame(sessConn, session, token)
+}
+
+// restore brings back a session from the store for a resume token
+// from before a restart.
+func (endpoint *WebsocketEndpoint) restore(token string, ws *websocket.Conn) *resumableSession {
+	var dot = strings.LastIndex(token, ".")
+	if !endpoint.storing() || dot <= 0 {
+		return nil
+	}
+	var id = token[:dot]
+
+	endpoint.restoreLock.Lock()
+	defer endpoint.restoreLock.Unlock()
+
+	if endpoint.context.Sessions().Find(id) != nil {
+		return nil
+	}
+
+	record, err := endpoint.Store.Load(id)
+	if err != nil {
+		fmt.Printf("Error loading session %s: %v\n", id, err)
+		return nil
+	}
+	if record == nil || !hasString(record.ResumeTokens, token) ||
+		record.Expired(time.Now(), endpoint.IdleTimeout, 0) {
+		return nil
+	}
+
+	var sessConn = newWebsocketSessionConnection(ws)
+	var session = endpoint.context.RestoreSession(record, sessConn)
+	if session.ID() != id {
+		endpoint.context.SessionClosed(session, "not restorable")
+		return nil
+	}
+
+	sessConn.resumeToken = token
+	var restored = &resumableSession{
+		session: session,
+		conn: sessConn,
+		token: token,
+	}
+	endpoint.resumeLock.Lock()
+	endpoint.resumable[token] = restored
+	endpoint.resumeLock.Unlock()
+
+	endpoint.Store.Touch(id)
+	return restored
+}
+
+// resumeTokens lists the tokens of the session's resumable
+// connections.
+func (endpoint *WebsocketEndpoint) resumeTokens(session Session) []string {
+	endpoint.resumeLock.Lock()
+	defer endpoint.resumeLock.Unlock()
+
+	var tokens []string
+	for token, resumable := range endpoint.resumable {
+		if resumable.session == session {
+			tokens = append(tokens, token)
+		}
+	}
+	sort.Strings(tokens)
+	return tokens
+}
+
+func hasString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (endpoint *WebsocketEndpoint) resume(token string, ws *websocket.Conn) *resumableSession {
+	endpoint.resumeLock.Lock()
+	defer endpoint.resumeLock.Unlock()
+
+	resumed, ok := endpoint.resumable[token]
+	if !ok {
+		return nil
+	}
+
+	// Kicked or otherwise closed in the meantime
+	if resumed.conn.isClosed() ||
+		endpoint.context.Sessions().Find(resumed.session.ID()) != resumed.session {
+		return nil
+	}
+
+	if resumed.timer != nil {
+		resumed.timer.Stop()
+		resumed.timer = nil
+	}
+	resumed.conn.attach(ws)
+	return resumed
+}
+
+func (endpoint *WebsocketEndpoint) detachSession(
+	session Session, sessConn *WebsocketSessionConnection,
+	ws *websocket.Conn, reason string) {
+
+	if !sessConn.detach(ws) {
+		// A resumed connection has taken over
+		return
+	}
+
+	endpoint.resumeLock.Lock()
+	var resumable = endpoint.resumable[sessConn.resumeToken]
+	if resumable != nil {
+		resumable.timer = time.AfterFunc(endpoint.ResumeGrace, func() {
+			endpoint.expireResumable(resumable, reason)
+		})
+	}
+	endpoint.resumeLock.Unlock()
+
+	if resumable == nil {
+		endpoint.closeSession(session, sessConn, reason)
+	}
+}
+
+func (endpoint *WebsocketEndpoint) expireResumable(resumable *resumableSession, reason string) {
+	endpoint.resumeLock.Lock()
+	if resumable.conn.attached() {
+		endpoint.resumeLock.Unlock()
+		return
+	}
+	delete(endpoint.resumable, resumable.token)
+	endpoint.resumeLock.Unlock()
+
+	resumable.conn.Close()
+	endpoint.closeSession(resumable.session, resumable.conn, reason)
+}
+
+// closeSession drops one of the session's connections, forgetting the
+// session once none are left.
+func (endpoint *WebsocketEndpoint) closeSession(
+	session Session, sessConn *WebsocketSessionConnection, reason string) {
+
+	sessConn.Close()
+	endpoint.context.ConnectionClosed(session, sessConn, reason)
+	if endpoint.context.Sessions().Find(session.ID()) == session {
+		endpoint.saveSession(session)
+		return
+	}
+
+	fmt.Printf("Session closed: %s\n", session.ID())
+	endpoint.forgetSession(session)
+}
+
+func sendSessionFrame(sessConn *WebsocketSessionConnection, session Session, token string) {
+	w := bytes.NewBufferString("")
+	w.WriteByte('s')
+	enc := msgpack.NewEncoder(w)
+	err := enc.Encode(APIData{
+		"session": session.ID(),
+		"resume": token,
+	})
+	if err != nil {
+		fmt.Printf("Encode err: %#v\n", err)
+		return
+	}
+	sessConn.Send(w.Bytes())
+}
+
+func randomToken() string {
+	var buf = make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(buf)
+}
@@ -3,14 +3,22 @@ package goservice
 import (
 	"os"
 	"log"
+	"sync"
+	"sync/atomic"
 )
 
 type Server struct {
 	services API
 
 	sessionCreator SessionCreator
+	userLoader UserLoader
 	endpoints []Endpoint
 	logger *log.Logger
+	topics *topicRegistry
+	sessions *SessionRegistry
+	hooks []SessionHooks
+	hookLock sync.RWMutex
+	logLevel int32
 
 	stopper chan os.Signal
 }
@@ -24,6 +32,9 @@ func NewServer(services API, sessionCreator SessionCreator, logger *log.Logger)
 		sessionCreator: sessionCreator,
 		endpoints: make([]Endpoint, 0),
 		logger: logger,
+		topics: newTopicRegistry(),
+		sessions: NewSessionRegistry(),
+		logLevel: int32(LogInfo),
 	}
 }
 
@@ -31,6 +42,20 @@ func (server *Server) AddEndpoint(endpoint Endpoint) {
 	server.endpoints = append(server.endpoints, endpoint)
 }
 
+// AddSessionHooks registers callbacks for session creation, login,
+// logout and close.
+func (server *Server) AddSessionHooks(hooks SessionHooks) {
+	server.hookLock.Lock()
+	server.hooks = append(server.hooks, hooks)
+	server.hookLock.Unlock()
+}
+
+func (server *Server) sessionHooks() []SessionHooks {
+	server.hookLock.RLock()
+	defer server.hookLock.RUnlock()
+	return server.hooks
+}
+
 func (server *Server) Start() {
 	for _, endpoint := range server.endpoints {
 		endpoint.Start()
@@ -52,8 +77,143 @@ func (server *Server) API() API {
 	return server.services
 }
 
+// SetUserLoader sets how restored sessions find their User again.
+// Without one, restored sessions come back logged out.
+func (server *Server) SetUserLoader(loader UserLoader) {
+	server.userLoader = loader
+}
+
 func (server *Server) CreateSession(conn SessionConnection) Session {
-	return server.sessionCreator(conn)
+	return server.registerSession(server.sessionCreator(conn))
+}
+
+// RestoreSession brings back a stored session on a new connection.
+// Sessions that aren't PersistentSessions get a fresh identity.
+func (server *Server) RestoreSession(record *SessionRecord, conn SessionConnection) Session {
+	var session = server.sessionCreator(conn)
+
+	if persistent, ok := session.(PersistentSession); ok {
+		var user User
+		if record.UserID != "" && server.userLoader != nil {
+			user = server.userLoader(record.UserID)
+		}
+		persistent.Restore(record, user)
+	}
+
+	return server.registerSession(session)
+}
+
+func (server *Server) registerSession(session Session) Session {
+	if observable, ok := session.(ObservableSession); ok {
+		observable.SetObserver(server)
+	}
+	server.sessions.add(session)
+
+	for _, hooks := range server.sessionHooks() {
+		if hooks.Created != nil {
+			hooks.Created(session)
+		}
+	}
+	return session
+}
+
+// SessionClosed is called by endpoints once a session's connection
+// has gone away. Only the first call for a session has any effect.
+func (server *Server) SessionClosed(session Session, reason string) {
+	if !server.sessions.remove(session) {
+		return
+	}
+	server.topics.unsubscribeAll(session)
+
+	for _, hooks := range server.sessionHooks() {
+		if hooks.Closed != nil {
+			hooks.Closed(session, reason)
+		}
+	}
+}
+
+// JoinSession adds another connection to a live session, e.g. a
+// second browser tab. It fails for sessions that have closed or can
+// only hold one connection.
+func (server *Server) JoinSession(session Session, conn SessionConnection) bool {
+	multi, ok := session.(MultiConnectionSession)
+	if !ok || server.sessions.Find(session.ID()) != session {
+		return false
+	}
+	multi.AddConnection(conn)
+	return true
+}
+
+// ConnectionClosed is called by endpoints when one of a session's
+// connections goes away. The session itself closes with its last
+// connection.
+func (server *Server) ConnectionClosed(session Session, conn SessionConnection, reason string) {
+	if multi, ok := session.(MultiConnectionSession); ok && multi.RemoveConnection(conn) > 0 {
+		return
+	}
+	server.SessionClosed(session, reason)
+}
+
+// UserChanged implements SessionObserver, firing the login and logout
+// hooks.
+func (server *Server) UserChanged(session Session, previous User, current User) {
+	if current != nil {
+		server.LogAt(LogDebug, "Session", "Login: %s (%s)", current.DisplayName(), session.ID())
+	} else if previous != nil {
+		server.LogAt(LogDebug, "Session", "Logout: %s (%s)", previous.DisplayName(), session.ID())
+	}
+
+	for _, hooks := range server.sessionHooks() {
+		if previous != nil && hooks.Logout != nil {
+			hooks.Logout(session, previous)
+		}
+		if current != nil && hooks.Login != nil {
+			hooks.Login(session, current)
+		}
+	}
+}
+
+// ------------------------------------------
+// Logging
+// ------------------------------------------
+
+// LogLevel is the least severe kind of message the server logs. Log
+// and LogPrefix log at LogInfo.
+type LogLevel int32
+
+const (
+	LogDebug LogLevel = iota
+	LogInfo
+	LogWarning
+	LogError
+	LogOff
+)
+
+var logLevelNames = []string{"debug", "info", "warning", "error", "off"}
+
+func (level LogLevel) String() string {
+	if level < 0 || int(level) >= len(logLevelNames) {
+		return "unknown"
+	}
+	return logLevelNames[level]
+}
+
+// ParseLogLevel returns the level with the given name.
+func ParseLogLevel(name string) (LogLevel, bool) {
+	for i, levelName := range logLevelNames {
+		if name == levelName {
+			return LogLevel(i), true
+		}
+	}
+	return LogInfo, false
+}
+
+func (server *Server) LogLevel() LogLevel {
+	return LogLevel(atomic.LoadInt32(&server.logLevel))
+}
+
+func (server *Server) SetLogLevel(level LogLevel) {
+	atomic.StoreInt32(&server.logLevel, int32(level))
 }
 
 func (server *Server) Log(format string, args... interface{}) {
@@ -61,5 +221,14 @@ func (server *Server) Log(format string, args... interface{}) {
 }
 
 func (server *Server) LogPrefix(prefix string, format string, args... interface{}) {
+	server.LogAt(LogInfo, prefix, format, args...)
+}
+
+// LogAt logs a message of the given severity, if the log level lets
+// it through.
+func (server *Server) LogAt(level LogLevel, prefix string, format string, args... interface{}) {
+	if level < server.LogLevel() {
+		return
+	}
 	server.logger.Printf("[ %-20s ] " + format + "\n", append([]interface{} { prefix }, args...)...)
 }
\ No newline at end of file
@@ -39,6 +39,18 @@ func (service *Service) AddMethod(
 	}
 }
 
+// Restrict requires a logged-in user to call a method, with one of
+// the given roles if there are any.
+func (service *Service) Restrict(name string, roles... string) {
+	method, ok := service.Methods[name]
+	if !ok {
+		panic("Restrict: no such method " + name)
+	}
+	method.RequireLogin = true
+	method.Roles = roles
+	service.Methods[name] = method
+}
+
 func (service *Service) Name() string {
 	return service.name
 }
@@ -75,15 +87,24 @@ func (collection *ServiceCollection) GetServices() map[string]APIService {
 
 func (collection ServiceCollection) HandleRequest(request APIData, session Session, context ServerContext) APIData {
 
+	if IsBatch(request) {
+		return HandleBatch(&collection, request, session, context)
+	}
+
 	ok, resolutionErrors, args := Parse(requestArgSpec, request)
 	if !ok {
 		return ErrorResponse(ListToStringSlice(resolutionErrors))
 	}
 
+	data, ok := args["data"].(APIData)
+	if !ok {
+		return ErrorResponse([]string{"Invalid value for data (expected object)"})
+	}
+
 	return Response(collection.HandleCall(
 		args["service"].(string), 
 		args["method"].(string),
-		args["data"].(APIData),
+		data,
 		session, context))
 }
 
@@ -105,6 +126,10 @@ func (collection ServiceCollection) HandleCall(
 		return false, []string{"No such method"}, nil
 	}
 
+	if denied := Authorize(method, session); denied != "" {
+		return false, []string{denied}, nil
+	}
+
 	ok, errors, args := Parse(method.ArgSpec, data)
 	if !ok {
 		return false, ListToStringSlice(errors), nil
@@ -118,6 +143,55 @@ func (collection ServiceCollection) HandleCall(
 	return true, nil, response
 }
 
+// Denial reasons, returned by Authorize and as HandleCall's error
+// when a restricted method is called without a login, or by a user
+// lacking the required role. They're strings rather than errors since
+// they travel in the []string of call errors.
+const (
+	DeniedUnauthorized = "Unauthorized"
+	DeniedForbidden = "Forbidden"
+)
+
+// Authorize returns DeniedUnauthorized or DeniedForbidden if session
+// may not call method, or "" if it may.
+func Authorize(method *APIMethod, session Session) string {
+	if !method.RequireLogin && len(method.Roles) == 0 {
+		return ""
+	}
+
+	var user User
+	if session != nil {
+		user = session.User()
+	}
+	if user == nil {
+		return DeniedUnauthorized
+	}
+
+	if len(method.Roles) == 0 {
+		return ""
+	}
+
+	if roleUser, ok := user.(RoleUser); ok {
+		for _, role := range roleUser.Roles() {
+			for _, required := range method.Roles {
+				if role == required {
+					return ""
+				}
+			}
+		}
+	}
+	return DeniedForbidden
+}
+
+// AccessDenied returns DeniedUnauthorized or DeniedForbidden if that
+// is why a call failed, or "".
+func AccessDenied(errors []string) string {
+	if len(errors) == 1 && (errors[0] == DeniedUnauthorized || errors[0] == DeniedForbidden) {
+		return errors[0]
+	}
+	return ""
+}
+
 func ListToStringSlice(l *list.List) []string {
 	var slice = make([]string, l.Len())
 	var i = 0
@@ -144,7 +218,14 @@ func Response(ok bool, errors []string, response APIData) APIData {
 func ErrorResponse(errors []string) APIData {
 	var response = make(APIData)
 	response["success"] = false
-	response["reason"] = "call error"
+	switch AccessDenied(errors) {
+	case DeniedUnauthorized:
+		response["reason"] = "unauthorized"
+	case DeniedForbidden:
+		response["reason"] = "forbidden"
+	default:
+		response["reason"] = "call error"
+	}
 	response["errors"] = errors
 	return response
 }
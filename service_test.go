@@ -0,0 +1,61 @@
+package goservice
+
+import (
+	"testing"
+)
+
+func TestAuthorize(t *testing.T) {
+	var open = &APIMethod{Name: "open"}
+	var login = &APIMethod{Name: "login", RequireLogin: true}
+	var admin = &APIMethod{Name: "admin", Roles: []string{"admin", "ops"}}
+
+	var anonymous = BasicSessionCreator(nil)
+	var user = BasicSessionCreator(nil)
+	user.SetUser(NewBasicUser("u1", "User", "staff"))
+	var operator = BasicSessionCreator(nil)
+	operator.SetUser(NewBasicUser("u2", "Operator", "staff", "ops"))
+
+	var cases = []struct {
+		method *APIMethod
+		session Session
+		denied string
+	}{
+		{open, nil, ""},
+		{open, anonymous, ""},
+		{login, nil, DeniedUnauthorized},
+		{login, anonymous, DeniedUnauthorized},
+		{login, user, ""},
+		{admin, anonymous, DeniedUnauthorized},
+		{admin, user, DeniedForbidden},
+		{admin, operator, ""},
+	}
+
+	for i, c := range cases {
+		if denied := Authorize(c.method, c.session); denied != c.denied {
+			t.Errorf("Case %d (%s): expected %q, got %q", i, c.method.Name, c.denied, denied)
+		}
+	}
+}
+
+func TestAccessDenied(t *testing.T) {
+	if AccessDenied([]string{DeniedForbidden}) != DeniedForbidden {
+		t.Errorf("Forbidden not recognised")
+	}
+	if AccessDenied([]string{"Missing argument: text"}) != "" {
+		t.Errorf("Call error taken for a denial")
+	}
+}
+
+func TestHarnessRestricted(t *testing.T) {
+	var harness = NewHarness(testAPI(), nil)
+	session, _ := harness.Connect()
+
+	expectReason(t, harness.Call(session, "test", "whoami", nil), "unauthorized")
+
+	session.SetUser(NewBasicUser("u1", "User"))
+	var response = harness.Call(session, "test", "whoami", nil)
+	if data, _ := response["data"].(APIData); data["id"] != "u1" {
+		t.Errorf("Wrong response: %v", response)
+	}
+	expectReason(t, harness.Call(session, "test", "admin", nil), "forbidden")
+}
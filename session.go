@@ -2,7 +2,7 @@ package goservice
 
 import (
 	"sync"
-	"fmt"
+	"time"
 
 	"code.google.com/p/go-uuid/uuid"
 )
@@ -13,7 +13,9 @@ func BasicSessionCreator(sessConn SessionConnection) Session {
 		id: uuid.New(),
 		user: nil,
 		Mutex: new(sync.Mutex),
-		connection: sessConn,
+		connections: []SessionConnection{ sessConn },
+		attributes: make(map[string]interface{}),
+		created: time.Now(),
 	}
 }
 
@@ -26,14 +28,182 @@ func (session *BasicSession) ID() string {
 }
 
 func (session *BasicSession) User() User {
+	session.Lock()
+	defer session.Unlock()
 	return session.user;
 }
 
+// SetUser logs the session in or out. The observer is told outside
+// the lock, so hooks may use the session.
 func (session *BasicSession) SetUser(user User) {
-	fmt.Printf("Session login: %s (%s)\n", user.DisplayName(), session.id)
+	session.Lock()
+	var previous, observer = session.user, session.observer
 	session.user = user
+	session.Unlock()
+
+	if observer != nil {
+		observer.UserChanged(session, previous, user)
+	}
+}
+
+func (session *BasicSession) SetObserver(observer SessionObserver) {
+	session.Lock()
+	session.observer = observer
+	session.Unlock()
 }
 
 func (session *BasicSession) Send(msg []byte) {
-	session.connection.Send(msg)
-}
\ No newline at end of file
+	for _, conn := range session.Connections() {
+		conn.Send(msg)
+	}
+}
+
+func (session *BasicSession) Push(data APIData) {
+	for _, conn := range session.Connections() {
+		conn.Push(data)
+	}
+}
+
+func (session *BasicSession) Close() {
+	for _, conn := range session.Connections() {
+		conn.Close()
+	}
+}
+
+// ConnectionInfo describes the session's first connection.
+func (session *BasicSession) ConnectionInfo() ConnectionInfo {
+	if conn := session.Connection(); conn != nil {
+		return conn.Info()
+	}
+	return ConnectionInfo{}
+}
+
+// ------------------------------------------
+// Connections
+// ------------------------------------------
+
+// Connection returns the session's first connection, or nil once they
+// have all gone.
+func (session *BasicSession) Connection() SessionConnection {
+	session.connectionLock.RLock()
+	defer session.connectionLock.RUnlock()
+	if len(session.connections) == 0 {
+		return nil
+	}
+	return session.connections[0]
+}
+
+func (session *BasicSession) Connections() []SessionConnection {
+	session.connectionLock.RLock()
+	defer session.connectionLock.RUnlock()
+	return append([]SessionConnection(nil), session.connections...)
+}
+
+func (session *BasicSession) AddConnection(conn SessionConnection) {
+	session.connectionLock.Lock()
+	defer session.connectionLock.Unlock()
+	for _, existing := range session.connections {
+		if existing == conn {
+			return
+		}
+	}
+	session.connections = append(session.connections, conn)
+}
+
+func (session *BasicSession) RemoveConnection(conn SessionConnection) int {
+	session.connectionLock.Lock()
+	defer session.connectionLock.Unlock()
+	for i, existing := range session.connections {
+		if existing == conn {
+			session.connections = append(session.connections[:i], session.connections[i+1:]...)
+			break
+		}
+	}
+	return len(session.connections)
+}
+
+// SendTo sends msg over one of the session's connections only,
+// reporting false if conn doesn't belong to the session.
+func (session *BasicSession) SendTo(conn SessionConnection, msg []byte) bool {
+	session.connectionLock.RLock()
+	var found = false
+	for _, existing := range session.connections {
+		if existing == conn {
+			found = true
+			break
+		}
+	}
+	session.connectionLock.RUnlock()
+
+	if found {
+		conn.Send(msg)
+	}
+	return found
+}
+
+// ------------------------------------------
+// Attributes
+// ------------------------------------------
+
+func (session *BasicSession) Get(key string) (interface{}, bool) {
+	session.attributeLock.RLock()
+	defer session.attributeLock.RUnlock()
+	val, ok := session.attributes[key]
+	return val, ok
+}
+
+func (session *BasicSession) Set(key string, val interface{}) {
+	session.attributeLock.Lock()
+	session.attributes[key] = val
+	session.attributeLock.Unlock()
+}
+
+func (session *BasicSession) Delete(key string) {
+	session.attributeLock.Lock()
+	delete(session.attributes, key)
+	session.attributeLock.Unlock()
+}
+
+// Attributes returns a copy of every attribute.
+func (session *BasicSession) Attributes() map[string]interface{} {
+	session.attributeLock.RLock()
+	defer session.attributeLock.RUnlock()
+	var attributes = make(map[string]interface{}, len(session.attributes))
+	for k, v := range session.attributes {
+		attributes[k] = v
+	}
+	return attributes
+}
+
+// ------------------------------------------
+// Persistence
+// ------------------------------------------
+
+func (session *BasicSession) Record() *SessionRecord {
+	var record = &SessionRecord{
+		ID: session.id,
+		Attributes: session.Attributes(),
+		Created: session.created,
+	}
+	if user := session.User(); user != nil {
+		record.UserID = user.ID()
+	}
+	return record
+}
+
+// Restore takes on a stored session's identity. It is only called
+// before the session is registered, so no hooks fire.
+func (session *BasicSession) Restore(record *SessionRecord, user User) {
+	session.Lock()
+	session.id = record.ID
+	session.user = user
+	session.created = record.Created
+	session.Unlock()
+
+	session.attributeLock.Lock()
+	session.attributes = make(map[string]interface{}, len(record.Attributes))
+	for k, v := range record.Attributes {
+		session.attributes[k] = v
+	}
+	session.attributeLock.Unlock()
+}
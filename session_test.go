@@ -0,0 +1,86 @@
+package goservice
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestSessionHooks(t *testing.T) {
+	var harness = NewHarness(testAPI(), nil)
+
+	var events []string
+	harness.Server.AddSessionHooks(SessionHooks{
+		Created: func(session Session) {
+			events = append(events, "created")
+		},
+		Login: func(session Session, user User) {
+			events = append(events, "login " + user.ID())
+		},
+		Logout: func(session Session, user User) {
+			events = append(events, "logout " + user.ID())
+		},
+		Closed: func(session Session, reason string) {
+			events = append(events, "closed " + reason)
+		},
+	})
+
+	session, _ := harness.Connect()
+	session.SetUser(NewBasicUser("u1", "One"))
+	session.SetUser(NewBasicUser("u2", "Two"))
+	session.SetUser(nil)
+	harness.Server.SessionClosed(session, "done")
+
+	var expected = "created, login u1, logout u1, login u2, logout u2, closed done"
+	if strings.Join(events, ", ") != expected {
+		t.Errorf("Expected %s, got %s", expected, strings.Join(events, ", "))
+	}
+}
+
+func TestSessionLoginLogging(t *testing.T) {
+	var output = new(bytes.Buffer)
+	var server = NewServer(testAPI(), BasicSessionCreator, log.New(output, "", 0))
+	var session = server.CreateSession(&LoopbackConnection{})
+
+	session.SetUser(NewBasicUser("u1", "One"))
+	if output.Len() != 0 {
+		t.Errorf("Login logged at info level: %s", output)
+	}
+
+	server.SetLogLevel(LogDebug)
+	session.SetUser(nil)
+	if !strings.Contains(output.String(), "Logout: One") {
+		t.Errorf("Logout not logged at debug level: %q", output)
+	}
+}
+
+func TestSessionAttributes(t *testing.T) {
+	var harness = NewHarness(testAPI(), nil)
+	conn, _ := harness.Connect()
+	var session = conn.(*BasicSession)
+
+	session.Set("theme", "dark")
+	session.Set("count", 2)
+	if theme, ok := session.Get("theme"); !ok || theme != "dark" {
+		t.Errorf("Wrong attribute: %v, %v", theme, ok)
+	}
+
+	var attributes = session.Attributes()
+	attributes["theme"] = "light"
+	if theme, _ := session.Get("theme"); theme != "dark" {
+		t.Errorf("Attributes returned the session's own map")
+	}
+
+	session.Delete("theme")
+	if _, ok := session.Get("theme"); ok {
+		t.Errorf("Deleted attribute still there")
+	}
+	if len(session.Attributes()) != 1 {
+		t.Errorf("Wrong attributes: %v", session.Attributes())
+	}
+
+	if info := session.ConnectionInfo(); info.Endpoint != "loopback" {
+		t.Errorf("Wrong connection info: %+v", info)
+	}
+}
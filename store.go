@@ -0,0 +1,350 @@
+package goservice
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SessionRecord is the part of a session that outlives its
+// connection. Attributes must survive a JSON round trip to be kept by
+// the file store.
+type SessionRecord struct {
+	ID string
+	UserID string
+	Attributes map[string]interface{}
+	Created time.Time
+	LastSeen time.Time
+
+	// ResumeTokens are the websocket resume tokens that may restore
+	// the session after a restart.
+	ResumeTokens []string `json:",omitempty"`
+
+	// TokenKey is part of the session's SessionTracker token, and
+	// changes when the session logs in or out.
+	TokenKey string `json:",omitempty"`
+}
+
+// SessionStore persists session records. Load returns nil, nil for an
+// unknown id. Save keeps the stored Created time and TokenKey when the
+// record's are zero. Expire deletes records idle for longer than idle or
+// older than maxAge (zero disables either check) and returns their ids.
+type SessionStore interface {
+	Load(id string) (*SessionRecord, error)
+	Save(record *SessionRecord) error
+	Delete(id string) error
+	Touch(id string) error
+	Expire(idle time.Duration, maxAge time.Duration) ([]string, error)
+}
+
+// PersistentSession is implemented by sessions that can be saved to
+// and restored from a SessionStore.
+type PersistentSession interface {
+	Record() *SessionRecord
+	Restore(record *SessionRecord, user User)
+}
+
+// UserLoader finds a User by User.ID() when restoring a session.
+type UserLoader func(string) User
+
+// NewSessionRecord captures a session's current state.
+func NewSessionRecord(session Session) *SessionRecord {
+	if persistent, ok := session.(PersistentSession); ok {
+		return persistent.Record()
+	}
+
+	var record = &SessionRecord{
+		ID: session.ID(),
+	}
+	if user := session.User(); user != nil {
+		record.UserID = user.ID()
+	}
+	return record
+}
+
+// keepStored fills in the fields Save keeps from an existing record.
+func keepStored(record *SessionRecord, existing *SessionRecord) {
+	if record.Created.IsZero() {
+		record.Created = existing.Created
+	}
+	if record.TokenKey == "" {
+		record.TokenKey = existing.TokenKey
+	}
+}
+
+func (record *SessionRecord) Expired(now time.Time, idle time.Duration, maxAge time.Duration) bool {
+	if idle > 0 && now.Sub(record.LastSeen) > idle {
+		return true
+	}
+	if maxAge > 0 && now.Sub(record.Created) > maxAge {
+		return true
+	}
+	return false
+}
+
+
+// ------------------------------------------
+// Memory store
+// ------------------------------------------
+
+type MemorySessionStore struct {
+	records map[string]*SessionRecord
+	sync.Mutex
+}
+
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{
+		records: make(map[string]*SessionRecord),
+	}
+}
+
+func (store *MemorySessionStore) Load(id string) (*SessionRecord, error) {
+	store.Lock()
+	defer store.Unlock()
+
+	record, ok := store.records[id]
+	if !ok {
+		return nil, nil
+	}
+	var copied = *record
+	return &copied, nil
+}
+
+func (store *MemorySessionStore) Save(record *SessionRecord) error {
+	var copied = *record
+	copied.LastSeen = time.Now()
+
+	store.Lock()
+	defer store.Unlock()
+
+	if existing, ok := store.records[record.ID]; ok {
+		keepStored(&copied, existing)
+	}
+	if copied.Created.IsZero() {
+		copied.Created = copied.LastSeen
+	}
+	store.records[record.ID] = &copied
+	return nil
+}
+
+func (store *MemorySessionStore) Delete(id string) error {
+	store.Lock()
+	delete(store.records, id)
+	store.Unlock()
+	return nil
+}
+
+func (store *MemorySessionStore) Touch(id string) error {
+	store.Lock()
+	defer store.Unlock()
+
+	if record, ok := store.records[id]; ok {
+		record.LastSeen = time.Now()
+	}
+	return nil
+}
+
+func (store *MemorySessionStore) Expire(idle time.Duration, maxAge time.Duration) ([]string, error) {
+	store.Lock()
+	defer store.Unlock()
+
+	var now = time.Now()
+	var expired []string
+	for id, record := range store.records {
+		if record.Expired(now, idle, maxAge) {
+			expired = append(expired, id)
+			delete(store.records, id)
+		}
+	}
+	return expired, nil
+}
+
+
+// ------------------------------------------
+// File store
+// ------------------------------------------
+
+// FileSessionStore keeps one JSON file per session in a directory, so
+// sessions survive a server restart.
+type FileSessionStore struct {
+	dir string
+	sync.Mutex
+}
+
+var errBadSessionID = errors.New("invalid session id")
+
+func NewFileSessionStore(dir string) (*FileSessionStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &FileSessionStore{
+		dir: dir,
+	}, nil
+}
+
+func (store *FileSessionStore) Load(id string) (*SessionRecord, error) {
+	store.Lock()
+	defer store.Unlock()
+	return store.read(id)
+}
+
+func (store *FileSessionStore) Save(record *SessionRecord) error {
+	var copied = *record
+	copied.LastSeen = time.Now()
+
+	store.Lock()
+	defer store.Unlock()
+
+	if existing, _ := store.read(record.ID); existing != nil {
+		keepStored(&copied, existing)
+	}
+	if copied.Created.IsZero() {
+		copied.Created = copied.LastSeen
+	}
+	return store.write(&copied)
+}
+
+func (store *FileSessionStore) Delete(id string) error {
+	path, err := store.path(id)
+	if err != nil {
+		return err
+	}
+
+	store.Lock()
+	defer store.Unlock()
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (store *FileSessionStore) Touch(id string) error {
+	store.Lock()
+	defer store.Unlock()
+
+	record, err := store.read(id)
+	if err != nil || record == nil {
+		return err
+	}
+	record.LastSeen = time.Now()
+	return store.write(record)
+}
+
+func (store *FileSessionStore) Expire(idle time.Duration, maxAge time.Duration) ([]string, error) {
+	store.Lock()
+	defer store.Unlock()
+
+	entries, err := ioutil.ReadDir(store.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var now = time.Now()
+	var expired []string
+	for _, entry := range entries {
+		var name = entry.Name()
+		if !strings.HasSuffix(name, ".json") {
+			continue
+		}
+
+		var id = strings.TrimSuffix(name, ".json")
+		record, err := store.read(id)
+		if err != nil || record == nil || !record.Expired(now, idle, maxAge) {
+			continue
+		}
+
+		if err := os.Remove(filepath.Join(store.dir, name)); err == nil {
+			expired = append(expired, id)
+		}
+	}
+	return expired, nil
+}
+
+func (store *FileSessionStore) path(id string) (string, error) {
+	if id == "" || strings.ContainsAny(id, `/\`) || strings.HasPrefix(id, ".") {
+		return "", errBadSessionID
+	}
+	return filepath.Join(store.dir, id + ".json"), nil
+}
+
+func (store *FileSessionStore) read(id string) (*SessionRecord, error) {
+	path, err := store.path(id)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var record SessionRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// write goes through a temporary file so a crash never leaves a
+// half-written record behind.
+func (store *FileSessionStore) write(record *SessionRecord) error {
+	path, err := store.path(record.ID)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	var tmp = path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+
+// SweepSessionStore expires records every interval until stop is
+// closed, closing any live sessions whose records expired. Run it in
+// its own goroutine.
+func SweepSessionStore(
+	store SessionStore, context ServerContext,
+	interval time.Duration, idle time.Duration, maxAge time.Duration,
+	stop <-chan bool) {
+
+	var ticker = time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			expireSessions(store, context, idle, maxAge)
+		}
+	}
+}
+
+func expireSessions(store SessionStore, context ServerContext, idle time.Duration, maxAge time.Duration) {
+	expired, err := store.Expire(idle, maxAge)
+	if err != nil {
+		context.Log("Session store expiry failed: %v", err)
+	}
+
+	for _, id := range expired {
+		if session := context.Sessions().Find(id); session != nil {
+			context.SessionClosed(session, "expired")
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package goservice
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func testStores(t *testing.T) (map[string]SessionStore, func()) {
+	dir, err := ioutil.TempDir("", "sessions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileStore, err := NewFileSessionStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stores = map[string]SessionStore{
+		"memory": NewMemorySessionStore(),
+		"file": fileStore,
+	}
+	return stores, func() { os.RemoveAll(dir) }
+}
+
+func TestSessionStore(t *testing.T) {
+	stores, cleanup := testStores(t)
+	defer cleanup()
+
+	for name, store := range stores {
+		if record, err := store.Load("missing"); record != nil || err != nil {
+			t.Errorf("%s: unknown id gave %v, %v", name, record, err)
+		}
+
+		var created = time.Now().Add(-time.Hour).Round(time.Second)
+		store.Save(&SessionRecord{
+			ID: "s1",
+			UserID: "u1",
+			Attributes: map[string]interface{}{"theme": "dark"},
+			Created: created,
+		})
+		store.Save(&SessionRecord{ID: "s1", UserID: "u2"})
+
+		record, err := store.Load("s1")
+		if err != nil || record == nil {
+			t.Fatalf("%s: load failed: %v", name, err)
+		}
+		if record.UserID != "u2" || !record.Created.Equal(created) || record.LastSeen.IsZero() {
+			t.Errorf("%s: wrong record %+v", name, record)
+		}
+
+		store.Delete("s1")
+		if record, _ := store.Load("s1"); record != nil {
+			t.Errorf("%s: deleted record loaded", name)
+		}
+	}
+}
+
+func TestSessionStoreExpire(t *testing.T) {
+	stores, cleanup := testStores(t)
+	defer cleanup()
+
+	for name, store := range stores {
+		store.Save(&SessionRecord{ID: "idle"})
+		store.Save(&SessionRecord{ID: "busy"})
+		store.Save(&SessionRecord{ID: "old", Created: time.Now().Add(-2 * time.Hour)})
+		time.Sleep(50 * time.Millisecond)
+		store.Touch("busy")
+
+		expired, err := store.Expire(40 * time.Millisecond, time.Hour)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		var gone = make(map[string]bool)
+		for _, id := range expired {
+			gone[id] = true
+		}
+		if len(expired) != 2 || !gone["idle"] || !gone["old"] {
+			t.Errorf("%s: wrong expiry %v", name, expired)
+		}
+		if record, _ := store.Load("busy"); record == nil {
+			t.Errorf("%s: touched record expired", name)
+		}
+	}
+}
+
+func TestFileSessionStoreIDs(t *testing.T) {
+	stores, cleanup := testStores(t)
+	defer cleanup()
+
+	for _, id := range []string{"", "../escape", `a\b`, ".hidden"} {
+		if err := stores["file"].Save(&SessionRecord{ID: id}); err != errBadSessionID {
+			t.Errorf("%q: expected %v, got %v", id, errBadSessionID, err)
+		}
+	}
+}
+
+func TestRestoreSession(t *testing.T) {
+	var harness = NewHarness(testAPI(), nil)
+	harness.Server.SetUserLoader(func(id string) User {
+		return NewBasicUser(id, "Restored")
+	})
+
+	var record = &SessionRecord{
+		ID: "s1",
+		UserID: "u1",
+		Attributes: map[string]interface{}{"theme": "dark"},
+	}
+	var session = harness.Server.RestoreSession(record, &LoopbackConnection{})
+
+	if session.ID() != "s1" || session.User() == nil || session.User().ID() != "u1" {
+		t.Errorf("Wrong session: %s %v", session.ID(), session.User())
+	}
+	if theme, _ := session.Get("theme"); theme != "dark" {
+		t.Errorf("Attributes not restored")
+	}
+	if harness.Server.Sessions().Find("s1") != session {
+		t.Errorf("Restored session not registered")
+	}
+
+	var saved = NewSessionRecord(session)
+	if saved.ID != "s1" || saved.UserID != "u1" || saved.Attributes["theme"] != "dark" {
+		t.Errorf("Wrong record: %+v", saved)
+	}
+}
@@ -1,52 +1,160 @@
 package goservice
 
 import (
-	"bufio"
+	"bytes"
 	"fmt"
 	"io"
 	"net"
-	"net/textproto"
+	"sort"
 	"strings"
-	"encoding/json"
 )
 
-type telnetCommand func(*telnetConnection, []string)
+// TelnetConsole is what a TelnetCommand sees of the connection it
+// runs on.
+type TelnetConsole interface {
+	Session() Session
+	Context() ServerContext
+	WriteLinef(string, ...interface{})
+	Close()
+}
+
+// TelnetCommand is a console command that isn't an API method, e.g.
+// for administration. Usage and Help are shown by "help"; Complete,
+// if set, offers completions for the word being typed after args.
+// RequireLogin and Roles restrict it like an APIMethod's.
+type TelnetCommand struct {
+	Name string
+	Usage string
+	Help string
+	Handler func(TelnetConsole, []string)
+	Complete func(TelnetConsole, []string, string) []string
+	RequireLogin bool
+	Roles []string
+}
 
 type TelnetEndpoint struct {
 	Address string
+
+	// Network is "tcp" by default, or "unix" to listen on a socket
+	// file at Address instead.
+	Network string
+
+	// Authenticators, if set, make each connection log in with a
+	// username and password before running any commands.
+	Authenticators []Authenticator
+	LoginAttempts int
+
+	// LineEditing offers clients server-side line editing, history and
+	// tab completion. Clients that decline keep their own line mode.
+	LineEditing bool
+
+	// Prompt is shown before each command, Banner once after login.
+	Prompt string
+	Banner string
+
 	context ServerContext
 	listener net.Listener
+	stopped chan bool
 	logPrefix string
-	commands map[string]telnetCommand
+	commands map[string]*TelnetCommand
 }
 
 type telnetConnection struct {
 	endpoint *TelnetEndpoint
 	conn net.Conn
+	session Session
+	sessConn *TelnetSessionConnection
+	lines *telnetLineReader
+	format string
 	closed bool
 	log func(string, ...interface{})
+
+	// In script mode, each command's output is captured and written
+	// as one JSON line; see telnetscript.go.
+	script bool
+	capture *bytes.Buffer
+	result APIData
+
+	// tokens is the line being run, for commands like "watch" that
+	// take a method call with key=value arguments.
+	tokens []telnetToken
+	watches map[int]*telnetWatch
+	lastWatch int
 }
 
-func NewTelnetEndpoint(address string, context ServerContext) Endpoint {
+func NewTelnetEndpoint(address string, context ServerContext) *TelnetEndpoint {
 	var endpoint = &TelnetEndpoint{
 		Address: address,
+		Network: "tcp",
+		LoginAttempts: 3,
+		LineEditing: true,
+		Prompt: "loge> ",
+		Banner: "Type 'help' for help",
 		context: context,
 		logPrefix: "Telnet " + address,
-		commands: make(map[string]telnetCommand),
+		commands: make(map[string]*TelnetCommand),
 	}
-	endpoint.commands["quit"] = telnet_command_quit
-	endpoint.commands["help"] = telnet_command_help
+	endpoint.AddCommand(TelnetCommand{
+		Name: "quit",
+		Help: "Close connection",
+		Handler: telnet_command_quit,
+	})
+	endpoint.AddCommand(TelnetCommand{
+		Name: "help",
+		Usage: "help [<service> <method> | <command>]",
+		Help: "Show this help",
+		Handler: telnet_command_help,
+	})
+	endpoint.AddCommand(TelnetCommand{
+		Name: "format",
+		Usage: "format [" + strings.Join(telnetFormats, "|") + "]",
+		Help: "Show or change how responses are printed",
+		Handler: telnet_command_format,
+		Complete: telnet_complete_format,
+	})
+	endpoint.AddCommand(TelnetCommand{
+		Name: "script",
+		Help: "Switch to non-interactive mode, one JSON response per line",
+		Handler: telnet_command_script,
+	})
+	endpoint.AddCommand(TelnetCommand{
+		Name: "watch",
+		Usage: "watch [topic <topic>... | <seconds> <service> <method> [args...]]",
+		Help: "Follow topics or re-run a method, or list what's being watched",
+		Handler: telnet_command_watch,
+	})
+	endpoint.AddCommand(TelnetCommand{
+		Name: "unwatch",
+		Usage: "unwatch <id>... | all",
+		Help: "Stop watching",
+		Handler: telnet_command_unwatch,
+		Complete: telnet_complete_unwatch,
+	})
 	return endpoint
 }
 
+// AddCommand registers a console command, replacing any of the same
+// name. Commands take precedence over services of the same name.
+func (endpoint *TelnetEndpoint) AddCommand(command TelnetCommand) {
+	endpoint.commands[command.Name] = &command
+}
+
 func (endpoint *TelnetEndpoint) Start() bool {
-	listener, err := net.Listen("tcp", endpoint.Address)
+	if endpoint.Network == "unix" {
+		if err := telnet_remove_socket(endpoint.Address); err != nil {
+			endpoint.Log("Error listening on %s: %s", endpoint.Address, err)
+			return false
+		}
+	}
+
+	listener, err := net.Listen(endpoint.Network, endpoint.Address)
 	if err != nil {
 		endpoint.Log("Error listening on %s: %s", endpoint.Address, err)
 		return false
 	}
 
 	endpoint.listener = listener
+	endpoint.stopped = make(chan bool)
 
 	go endpoint.Listen()
 
@@ -56,14 +164,20 @@ func (endpoint *TelnetEndpoint) Start() bool {
 }
 
 func (endpoint *TelnetEndpoint) Listen() {
+	var listener, stopped = endpoint.listener, endpoint.stopped
 	for {
-		conn, err := endpoint.listener.Accept()
+		conn, err := listener.Accept()
 		if err != nil {
+			select {
+			case <-stopped:
+				return
+			default:
+			}
 			endpoint.Log("Error accepting: %s", err)
 			continue
 		}
 
-		var prefix = fmt.Sprintf("[ %s ] ", conn.RemoteAddr())
+		var prefix = fmt.Sprintf("[ %s ] ", telnet_remote_addr(conn))
 		var log = func(fmt string, args... interface{}) {
 			endpoint.Log(prefix + fmt, args...)
 		}
@@ -71,6 +185,7 @@ func (endpoint *TelnetEndpoint) Listen() {
 		var tConn = &telnetConnection{
 			endpoint: endpoint,
 			conn: conn,
+			format: "pretty",
 			closed: false,
 			log: log,
 		}
@@ -78,7 +193,18 @@ func (endpoint *TelnetEndpoint) Listen() {
 	}
 }
 
+// Stop closes the listener, removing the socket file of a unix
+// endpoint. Open connections carry on until they quit.
 func (endpoint *TelnetEndpoint) Stop() bool {
+	if endpoint.listener == nil {
+		return true
+	}
+	close(endpoint.stopped)
+	endpoint.listener.Close()
+	endpoint.listener = nil
+	if endpoint.Network == "unix" {
+		telnet_remove_socket(endpoint.Address)
+	}
 	return true
 }
 
@@ -89,13 +215,41 @@ func (endpoint *TelnetEndpoint) Log(fmt string, args... interface{}) {
 
 func (tc *telnetConnection) Loop() {
 	tc.log("Connection started")
-	tc.WriteLinef("Type 'help' for help")
 
-	var reader = textproto.NewReader(bufio.NewReader(tc.conn))
+	tc.sessConn = newTelnetSessionConnection(tc)
+	tc.session = tc.endpoint.context.CreateSession(tc.sessConn)
+	var reason = "connection closed"
+	defer func() {
+		tc.unwatchAll()
+		tc.sessConn.Close()
+		tc.endpoint.context.ConnectionClosed(tc.session, tc.sessConn, reason)
+	}()
+
+	tc.lines = newTelnetLineReader(tc.conn, tc.sessConn.write)
+	tc.lines.modeChanged = tc.sessConn.setCRLF
+	tc.lines.complete = tc.complete
+	go tc.sessConn.deliver()
+	if tc.endpoint.LineEditing {
+		tc.lines.negotiate()
+	}
+
+	if len(tc.endpoint.Authenticators) > 0 && !tc.login() {
+		reason = "login failed"
+		tc.log("Login failed")
+		return
+	}
+
+	if tc.endpoint.Banner != "" {
+		tc.WriteLinef("%s", tc.endpoint.Banner)
+	}
+
 	for !tc.closed {
-		tc.conn.Write([]byte("loge> "))
+		var prompt = tc.endpoint.Prompt
+		if tc.script {
+			prompt = ""
+		}
 
-		line, err := reader.ReadLine()
+		line, err := tc.lines.ReadLine(prompt)
 		if err != nil {
 			if err != io.EOF {
 				tc.log("Connection error: %#v", err)
@@ -103,49 +257,229 @@ func (tc *telnetConnection) Loop() {
 			break
 		}
 
-		var tokens []string
-		for _, tok := range strings.Split(string(line), " ") {
-			if len(tok) > 0 {
-				tokens = append(tokens, tok)
-			}
+		if strings.TrimSpace(line) == "" {
+			continue
 		}
 
-		if len(tokens) == 0 {
-			continue
+		if tc.script {
+			tc.runScripted(line)
+		} else {
+			tc.handle(line)
 		}
+	}
 
-		handler, ok := tc.endpoint.commands[tokens[0]]
-		if ok {
-			handler(tc, tokens[1:])
-			continue
+	tc.log("Connection closed")
+}
+
+func (tc *telnetConnection) handle(line string) {
+	tokens, err := telnet_tokenize(line)
+	if err != nil {
+		tc.fail(err.Error())
+		return
+	}
+
+	if len(tokens) == 0 {
+		return
+	}
+
+	command, ok := tc.endpoint.commands[tokens[0].Value]
+	if ok {
+		tc.tokens = tokens
+		var words []string
+		for _, token := range tokens[1:] {
+			words = append(words, token.Value)
+		}
+		tc.run(command, words)
+		return
+	}
+
+	telnet_dispatch(tc, tokens)
+}
+
+// login prompts for a username and password until one of the
+// endpoint's authenticators accepts them.
+func (tc *telnetConnection) login() bool {
+	for attempt := 0; attempt < tc.endpoint.LoginAttempts; attempt++ {
+		username, err := tc.lines.read("Username: ", false)
+		if err != nil {
+			return false
 		}
 
-		telnet_dispatch(tc, tokens)
+		password, err := tc.lines.ReadPassword("Password: ")
+		if err != nil {
+			return false
+		}
+
+		var credentials = APIData{
+			"username": strings.TrimSpace(username),
+			"password": password,
+		}
+		for _, authenticator := range tc.endpoint.Authenticators {
+			user, err := authenticator.Authenticate(credentials)
+			if err == ErrNoCredentials {
+				continue
+			}
+			if err == nil {
+				tc.session.SetUser(user)
+				tc.WriteLinef("Logged in as %s", user.DisplayName())
+				return true
+			}
+			if err != ErrBadCredentials {
+				tc.log("Authentication error: %v", err)
+			}
+			break
+		}
+
+		tc.WriteLinef("Login incorrect")
 	}
-		
-	tc.conn.Close()
-	tc.log("Connection closed")
+	return false
+}
+
+func (tc *telnetConnection) run(command *TelnetCommand, args []string) {
+	var restriction = &APIMethod{
+		RequireLogin: command.RequireLogin,
+		Roles: command.Roles,
+	}
+	if denied := Authorize(restriction, tc.session); denied != "" {
+		tc.fail(denied)
+		return
+	}
+	command.Handler(tc, args)
+}
+
+func (tc *telnetConnection) Session() Session {
+	return tc.session
+}
+
+func (tc *telnetConnection) Context() ServerContext {
+	return tc.endpoint.context
+}
+
+// Close ends the connection once the current command returns.
+func (tc *telnetConnection) Close() {
+	tc.closed = true
 }
 
 func (tc *telnetConnection) WriteLinef(format string, args... interface{}) {
-	tc.conn.Write([]byte(fmt.Sprintf(format + "\n", args...)))
+	if tc.capture != nil {
+		fmt.Fprintf(tc.capture, format + "\n", args...)
+		return
+	}
+	tc.sessConn.write([]byte(fmt.Sprintf(format + "\n", args...)))
+}
+
+// complete offers completions for the word ending before, which
+// follows the already typed words: commands and service names, then
+// method names, then the method's unused argument names.
+func (tc *telnetConnection) complete(before string) (string, []string) {
+	var word = before[strings.LastIndex(before, " ")+1:]
+	tokens, err := telnet_tokenize(before[:len(before)-len(word)])
+	if err != nil || strings.Contains(word, "=") {
+		return word, nil
+	}
+
+	var words []string
+	for _, token := range tokens {
+		words = append(words, token.Value)
+	}
+
+	var api = tc.endpoint.context.API()
+	var services = api.GetServices()
+
+	if len(words) == 0 {
+		var candidates []string
+		for name := range tc.endpoint.commands {
+			candidates = append(candidates, name)
+		}
+		if len(services) == 1 {
+			for _, service := range services {
+				for name := range service.GetMethods() {
+					candidates = append(candidates, name)
+				}
+			}
+		}
+		for name := range services {
+			candidates = append(candidates, name)
+		}
+		return word, matching(candidates, word)
+	}
+
+	var withArgs = true
+	if words[0] == "help" {
+		words, withArgs = words[1:], false
+	} else if command, ok := tc.endpoint.commands[words[0]]; ok {
+		if command.Complete == nil {
+			return word, nil
+		}
+		return word, matching(command.Complete(tc, words[1:], word), word)
+	}
+
+	if len(words) == 0 {
+		var candidates []string
+		for name := range services {
+			candidates = append(candidates, name)
+		}
+		return word, matching(candidates, word)
+	}
+
+	service, rest := telnet_get_service(api, words)
+	if service == nil {
+		return word, nil
+	}
+
+	if len(rest) == 0 {
+		var candidates []string
+		for name := range service.GetMethods() {
+			candidates = append(candidates, name)
+		}
+		return word, matching(candidates, word)
+	}
+
+	var method = service.FindMethod(rest[0])
+	if method == nil || !withArgs {
+		return word, nil
+	}
+
+	var given = make(map[string]bool)
+	for _, token := range tokens {
+		given[token.Key] = true
+	}
+
+	var candidates []string
+	for _, arg := range method.ArgSpec {
+		if !given[arg.Name] {
+			candidates = append(candidates, arg.Name + "=")
+		}
+	}
+	return word, matching(candidates, word)
 }
 
 // ------------------------------------
 // Commands
 // ------------------------------------
 
-func telnet_command_quit(tc *telnetConnection, args []string) {
-	tc.WriteLinef("Bye!")
-	tc.closed = true
+func telnet_command_quit(console TelnetConsole, args []string) {
+	console.WriteLinef("Bye!")
+	console.Close()
 }
 
-func telnet_command_help(tc *telnetConnection, args []string) {
+func telnet_command_help(console TelnetConsole, args []string) {
+	var tc = console.(*telnetConnection)
 	var api = tc.endpoint.context.API()
 
 	if len(args) == 0 {
-		tc.WriteLinef("  help -- Show this help")
-		tc.WriteLinef("  quit -- Close connection")
+		var names []string
+		for name := range tc.endpoint.commands {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			tc.WriteLinef("  %s -- %s", name, tc.endpoint.commands[name].Help)
+		}
+
+		tc.WriteLinef("")
+		tc.WriteLinef("Call methods as: <service> <method> [value | name=value ...]")
+		tc.WriteLinef("Quote values containing spaces; give nested values as JSON.")
 
 		var services = api.GetServices()
 		if len(services) == 1 {
@@ -164,6 +498,15 @@ func telnet_command_help(tc *telnetConnection, args []string) {
 		return
 	}
 
+	if command, ok := tc.endpoint.commands[args[0]]; ok {
+		var usage = command.Usage
+		if usage == "" {
+			usage = command.Name
+		}
+		tc.WriteLinef("%s -- %s", usage, command.Help)
+		return
+	}
+
 	service, args := telnet_get_service(api, args)
 	if service == nil || len(args) == 0 {
 		tc.WriteLinef("Usage: help <service> <command>")
@@ -192,10 +535,16 @@ func telnet_command_help(tc *telnetConnection, args []string) {
 
 }
 
+// telnet_get_service picks the service a command line names. With
+// only one service, naming it is optional, though clients that always
+// send it (like client.TelnetClient) may.
 func telnet_get_service(api API, args []string) (APIService, []string) {
 	var services = api.GetServices()
 	if len(services) == 1 {
 		for _, service := range services {
+			if len(args) > 0 && args[0] == service.Name() {
+				args = args[1:]
+			}
 			return service, args
 		}
 	}
@@ -218,52 +567,75 @@ func telnet_list_service_commands(tc *telnetConnection, service APIService) {
 	}
 }
 
-func telnet_dispatch(tc *telnetConnection, args []string) {
-	var api = tc.endpoint.context.API()
+// telnet_dispatch makes the call through API.HandleCall, printing the
+// same response envelope the other endpoints return.
+func telnet_dispatch(tc *telnetConnection, tokens []telnetToken) {
+	serviceName, methodName, args, ok := telnet_call_args(tc, tokens)
+	if !ok {
+		return
+	}
 
-	var service APIService
-	service, args = telnet_get_service(api, args)
+	ok, errors, response := tc.endpoint.context.API().HandleCall(
+		serviceName, methodName, args, tc.session, tc.endpoint.context)
+	telnet_show_response(tc, ok, errors, response)
+}
 
-	if len(args) == 0 {
-		tc.WriteLinef("No command given")
+// telnet_show_response prints a call's response in the connection's
+// format, or makes it the result in script mode.
+func telnet_show_response(tc *telnetConnection, ok bool, errors []string, response APIData) {
+	if tc.script {
+		tc.result = Response(ok, errors, response)
 		return
 	}
 
-	command, args := args[0], args[1:]
+	output, err := telnet_format(tc.format, ok, errors, response)
+	if err != nil {
+		tc.WriteLinef("Error encoding response: %s", err)
+		return
+	}
 
-	method, ok := service.GetMethods()[command]
+	tc.WriteLinef("%s", output)
+}
 
-	if !ok {
-		tc.WriteLinef("Unknown %s method '%s'", service.Name(), command)
-		return
+// telnet_call_args splits a command line into service and method
+// names and maps positional and key=value arguments onto the
+// method's ArgSpec. Unknown services and methods are left for
+// HandleCall to report.
+func telnet_call_args(tc *telnetConnection, tokens []telnetToken) (string, string, APIData, bool) {
+	var api = tc.endpoint.context.API()
+
+	var words = make([]string, len(tokens))
+	for i, token := range tokens {
+		words[i] = token.Value
 	}
 
-	var mapArgs = make(APIData)
-	for i, arg := range args {
-		if i >= len(method.ArgSpec) {
-			tc.WriteLinef("Too many arguments")
-			return
-		}
-		mapArgs[method.ArgSpec[i].Name] = arg
+	var serviceName string
+	service, rest := telnet_get_service(api, words)
+	if service != nil {
+		serviceName = service.Name()
+	} else {
+		serviceName, rest = words[0], words[1:]
 	}
+	tokens = tokens[len(tokens)-len(rest):]
 
-	ok, errors, funcArgs := Parse(method.ArgSpec, mapArgs)
-	if !ok {
-		tc.WriteLinef("Parse errors:")
-		for _, line := range ListToStringSlice(errors) {
-			tc.WriteLinef(" %s", line)
-		}
-		return
+	if len(tokens) == 0 || tokens[0].Key != "" {
+		tc.fail("No command given")
+		return "", "", nil, false
 	}
 
-	ok, response := method.Handler(funcArgs, nil, tc.endpoint.context)
+	var methodName = tokens[0].Value
+	tokens = tokens[1:]
 
-	jsonResponse, err := json.MarshalIndent(response, "", "  ")
-	if err != nil {
-		tc.WriteLinef("Error encoding response: %s", err)
-		return
+	var args = make(APIData)
+	if service != nil {
+		if method := service.FindMethod(methodName); method != nil {
+			var err error
+			if args, err = telnet_map_args(method, tokens); err != nil {
+				tc.fail(err.Error())
+				return "", "", nil, false
+			}
+		}
 	}
 
-	tc.WriteLinef("%s", jsonResponse)
+	return serviceName, methodName, args, true
 }
-		
@@ -0,0 +1,115 @@
+package goservice
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testTelnetEndpoint starts a telnet endpoint, letting configure set
+// it up first.
+func testTelnetEndpoint(t *testing.T, api API, configure func(*TelnetEndpoint)) *TelnetEndpoint {
+	var harness = NewHarness(api, nil)
+	var endpoint = NewTelnetEndpoint("127.0.0.1:0", harness.Server)
+	endpoint.LineEditing = false
+	if configure != nil {
+		configure(endpoint)
+	}
+	if !endpoint.Start() {
+		t.Fatalf("Telnet endpoint didn't start")
+	}
+	return endpoint
+}
+
+type telnetTestClient struct {
+	t *testing.T
+	conn net.Conn
+	reader *bufio.Reader
+}
+
+// scriptTelnet connects to endpoint and switches to script mode.
+func scriptTelnet(t *testing.T, endpoint *TelnetEndpoint) *telnetTestClient {
+	conn, err := net.Dial("tcp", endpoint.listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	var client = &telnetTestClient{t, conn, bufio.NewReader(conn)}
+	if result := client.run("script"); result["output"] != "script mode\n" {
+		t.Fatalf("No script mode: %v", result)
+	}
+	return client
+}
+
+func (client *telnetTestClient) send(line string) {
+	if _, err := client.conn.Write([]byte(line + "\n")); err != nil {
+		client.t.Fatalf("Write failed: %v", err)
+	}
+}
+
+// next reads the next JSON line, skipping the banner and prompts.
+func (client *telnetTestClient) next() APIData {
+	client.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		line, err := client.reader.ReadString('\n')
+		if err != nil {
+			client.t.Fatalf("Read failed: %v", err)
+		}
+		var start = strings.Index(line, "{")
+		if start < 0 {
+			continue
+		}
+
+		var result interface{}
+		if err := json.Unmarshal([]byte(line[start:]), &result); err != nil {
+			client.t.Fatalf("Bad line %q: %v", line, err)
+		}
+		return ToAPIData(result).(APIData)
+	}
+}
+
+func (client *telnetTestClient) run(line string) APIData {
+	client.send(line)
+	return client.next()
+}
+
+func (client *telnetTestClient) Close() {
+	client.conn.Close()
+}
+
+func TestTelnetGetService(t *testing.T) {
+	var single = NewServiceCollection()
+	single.AddService(NewService("users"))
+
+	var several = NewServiceCollection()
+	several.AddService(NewService("users"))
+	several.AddService(NewService("groups"))
+
+	var cases = []struct {
+		api API
+		args []string
+		service string
+		rest []string
+	}{
+		{single, []string{"get", "id=1"}, "users", []string{"get", "id=1"}},
+		{single, []string{"users", "get", "id=1"}, "users", []string{"get", "id=1"}},
+		{single, []string{}, "users", []string{}},
+		{several, []string{"groups", "list"}, "groups", []string{"list"}},
+		{several, []string{"nope", "list"}, "", []string{"nope", "list"}},
+		{several, []string{}, "", []string{}},
+	}
+
+	for i, c := range cases {
+		service, rest := telnet_get_service(c.api, c.args)
+		var name = ""
+		if service != nil {
+			name = service.Name()
+		}
+		if name != c.service || !reflect.DeepEqual(rest, c.rest) {
+			t.Errorf("Case %d: expected %s %v, got %s %v", i, c.service, c.rest, name, rest)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package goservice
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// AddAdminCommands registers the "sessions", "kick", "loglevel" and
+// "stats" console commands. They always need a logged-in user, e.g.
+// one of the endpoint's Authenticators; with roles given, the user
+// must also have one of them.
+func (endpoint *TelnetEndpoint) AddAdminCommands(roles... string) {
+	endpoint.AddCommand(TelnetCommand{
+		Name: "sessions",
+		Usage: "sessions [<user id>]",
+		Help: "List sessions, optionally only those of one user",
+		Handler: telnet_command_sessions,
+		RequireLogin: true,
+		Roles: roles,
+	})
+	endpoint.AddCommand(TelnetCommand{
+		Name: "kick",
+		Usage: "kick <session id>...",
+		Help: "Close sessions",
+		Handler: telnet_command_kick,
+		Complete: telnet_complete_session,
+		RequireLogin: true,
+		Roles: roles,
+	})
+	endpoint.AddCommand(TelnetCommand{
+		Name: "loglevel",
+		Usage: "loglevel [" + strings.Join(logLevelNames, "|") + "]",
+		Help: "Show or change the server's log level",
+		Handler: telnet_command_loglevel,
+		Complete: telnet_complete_loglevel,
+		RequireLogin: true,
+		Roles: roles,
+	})
+	endpoint.AddCommand(TelnetCommand{
+		Name: "stats",
+		Help: "Show session and runtime statistics",
+		Handler: telnet_command_stats,
+		RequireLogin: true,
+		Roles: roles,
+	})
+}
+
+func telnet_command_sessions(console TelnetConsole, args []string) {
+	var registry = console.Context().Sessions()
+
+	var sessions []Session
+	if len(args) > 0 {
+		sessions = registry.ForUser(args[0])
+	} else {
+		sessions = registry.All()
+	}
+	sort.Sort(sessionsByID(sessions))
+
+	var buf = new(bytes.Buffer)
+	var table = tabwriter.NewWriter(buf, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "ID\tUSER\tENDPOINT\tADDRESS")
+	for _, session := range sessions {
+		var user = "-"
+		if session.User() != nil {
+			user = session.User().ID()
+		}
+		var info = session.ConnectionInfo()
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\n", session.ID(), user, info.Endpoint, info.RemoteAddr)
+	}
+	table.Flush()
+
+	console.WriteLinef("%s%d session(s)", buf.String(), len(sessions))
+}
+
+func telnet_command_kick(console TelnetConsole, args []string) {
+	if len(args) == 0 {
+		console.WriteLinef("Usage: kick <session id>...")
+		return
+	}
+
+	for _, id := range args {
+		if console.Session() != nil && id == console.Session().ID() {
+			console.WriteLinef("%s: that's this session; use quit", id)
+			continue
+		}
+		if console.Context().Kick(id) {
+			console.WriteLinef("%s: kicked", id)
+		} else {
+			console.WriteLinef("%s: no such session", id)
+		}
+	}
+}
+
+func telnet_command_loglevel(console TelnetConsole, args []string) {
+	var context = console.Context()
+
+	if len(args) > 0 {
+		level, ok := ParseLogLevel(args[0])
+		if !ok {
+			console.WriteLinef("Unknown log level '%s' (one of %s)", args[0], strings.Join(logLevelNames, ", "))
+			return
+		}
+		context.SetLogLevel(level)
+	}
+	console.WriteLinef("Log level: %s", context.LogLevel())
+}
+
+func telnet_complete_loglevel(console TelnetConsole, args []string, word string) []string {
+	if len(args) > 0 {
+		return nil
+	}
+	return logLevelNames
+}
+
+func telnet_command_stats(console TelnetConsole, args []string) {
+	var registry = console.Context().Sessions()
+
+	var endpoints = make(map[string]int)
+	registry.Each(func(session Session) {
+		endpoints[session.ConnectionInfo().Endpoint]++
+	})
+
+	var names []string
+	for name := range endpoints {
+		names = append(names, fmt.Sprintf("%s=%d", name, endpoints[name]))
+	}
+	sort.Strings(names)
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	console.WriteLinef("Sessions:   %d (%s)", registry.Count(), strings.Join(names, ", "))
+	console.WriteLinef("Users:      %d", registry.UserCount())
+	console.WriteLinef("Goroutines: %d", runtime.NumGoroutine())
+	console.WriteLinef("Memory:     %d KB in use, %d KB from system", mem.Alloc / 1024, mem.Sys / 1024)
+}
+
+func telnet_complete_session(console TelnetConsole, args []string, word string) []string {
+	var ids []string
+	console.Context().Sessions().Each(func(session Session) {
+		ids = append(ids, session.ID())
+	})
+	return ids
+}
+
+
+type sessionsByID []Session
+
+func (s sessionsByID) Len() int { return len(s) }
+func (s sessionsByID) Less(i, j int) bool { return s[i].ID() < s[j].ID() }
+func (s sessionsByID) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
@@ -0,0 +1,60 @@
+package goservice
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTelnetAdminCommands(t *testing.T) {
+	var keys = NewAPIKeyAuthenticator(func(id string) User {
+		if id == "root" {
+			return NewBasicUser(id, id, "admin")
+		}
+		return NewBasicUser(id, id)
+	})
+	keys.AddKey("user-key", "alice")
+	keys.AddKey("admin-key", "root")
+
+	var api = testAPI().(*ServiceCollection)
+	api.AddService(NewAuthService(keys))
+
+	var cases = []struct {
+		roles []string
+		key string
+		reason string
+	}{
+		{nil, "", "unauthorized"},
+		{nil, "user-key", ""},
+		{[]string{"admin"}, "user-key", "forbidden"},
+		{[]string{"admin"}, "admin-key", ""},
+	}
+
+	for _, c := range cases {
+		var endpoint = testTelnetEndpoint(t, api, func(endpoint *TelnetEndpoint) {
+			endpoint.AddAdminCommands(c.roles...)
+		})
+		var client = scriptTelnet(t, endpoint)
+
+		if c.key != "" {
+			client.run("auth login key=" + c.key)
+		}
+		for _, command := range []string{"sessions", "kick nobody", "loglevel", "stats"} {
+			var result = client.run(command)
+			if c.reason != "" {
+				expectReason(t, result, c.reason)
+			} else if result["success"] != true {
+				t.Errorf("%s with %v as %s: %v", command, c.roles, c.key, result)
+			}
+		}
+
+		if c.reason == "" {
+			var output, _ = client.run("sessions")["output"].(string)
+			if !strings.Contains(output, "1 session(s)") {
+				t.Errorf("Wrong sessions output: %q", output)
+			}
+		}
+
+		client.Close()
+		endpoint.Stop()
+	}
+}
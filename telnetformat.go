@@ -0,0 +1,222 @@
+package goservice
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// Telnet output formats, chosen per connection with "format". The
+// JSON formats print the response envelope as the other endpoints
+// return it; table and yaml print an OK or FAILED line and then the
+// data or errors.
+var telnetFormats = []string{"pretty", "json", "table", "yaml"}
+
+func telnet_command_format(console TelnetConsole, args []string) {
+	var tc = console.(*telnetConnection)
+
+	if len(args) == 0 {
+		tc.WriteLinef("Output format: %s", tc.format)
+		return
+	}
+
+	for _, format := range telnetFormats {
+		if args[0] == format {
+			tc.lines.Lock()
+			tc.format = format
+			tc.lines.Unlock()
+			tc.WriteLinef("Output format: %s", tc.format)
+			return
+		}
+	}
+	tc.WriteLinef("Unknown format '%s' (one of %s)", args[0], strings.Join(telnetFormats, ", "))
+}
+
+func telnet_complete_format(console TelnetConsole, args []string, word string) []string {
+	if len(args) > 0 {
+		return nil
+	}
+	return telnetFormats
+}
+
+func telnet_format(format string, ok bool, errors []string, response APIData) (string, error) {
+	var envelope = Response(ok, errors, response)
+
+	switch format {
+	case "json":
+		encoded, err := json.Marshal(envelope)
+		return string(encoded), err
+	case "table", "yaml":
+		var buf = new(bytes.Buffer)
+		if ok {
+			buf.WriteString("OK\n")
+		} else {
+			fmt.Fprintf(buf, "FAILED (%s)\n", envelope["reason"])
+		}
+
+		var body = envelope["data"]
+		if !ok {
+			body = envelope["errors"]
+		}
+		telnet_write_yaml(buf, body, 0, format == "table")
+		return strings.TrimRight(buf.String(), "\n"), nil
+	}
+
+	encoded, err := json.MarshalIndent(envelope, "", "  ")
+	return string(encoded), err
+}
+
+// telnet_write_yaml writes maps as "key: value" lines and lists as
+// "- item" lines, indenting nested values. With tables set, lists of
+// maps are written as aligned columns instead.
+func telnet_write_yaml(buf *bytes.Buffer, val interface{}, indent int, tables bool) {
+	var pad = strings.Repeat("  ", indent)
+
+	if rows, ok := telnet_rows(val); ok && tables {
+		telnet_write_table(buf, rows, pad)
+		return
+	}
+
+	switch v := reflect.ValueOf(val); v.Kind() {
+	case reflect.Map:
+		if v.Len() == 0 {
+			buf.WriteString(pad + "{}\n")
+			return
+		}
+		var keys = telnet_map_keys(v)
+		for _, key := range keys {
+			var item = v.MapIndex(reflect.ValueOf(key)).Interface()
+			if telnet_is_scalar(item) {
+				fmt.Fprintf(buf, "%s%s: %s\n", pad, key, telnet_scalar(item))
+				continue
+			}
+			fmt.Fprintf(buf, "%s%s:\n", pad, key)
+			telnet_write_yaml(buf, item, indent+1, tables)
+		}
+
+	case reflect.Slice, reflect.Array:
+		if v.Len() == 0 {
+			buf.WriteString(pad + "[]\n")
+			return
+		}
+		for i := 0; i < v.Len(); i++ {
+			var item = v.Index(i).Interface()
+			if telnet_is_scalar(item) {
+				fmt.Fprintf(buf, "%s- %s\n", pad, telnet_scalar(item))
+				continue
+			}
+			fmt.Fprintf(buf, "%s-\n", pad)
+			telnet_write_yaml(buf, item, indent+1, tables)
+		}
+
+	default:
+		buf.WriteString(pad + telnet_scalar(val) + "\n")
+	}
+}
+
+func telnet_write_table(buf *bytes.Buffer, rows []map[string]interface{}, pad string) {
+	var columns = make(map[string]bool)
+	for _, row := range rows {
+		for key := range row {
+			columns[key] = true
+		}
+	}
+
+	var names []string
+	for name := range columns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var table = tabwriter.NewWriter(buf, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, pad + strings.ToUpper(strings.Join(names, "\t")))
+	for _, row := range rows {
+		var cells []string
+		for _, name := range names {
+			var cell, ok = row[name]
+			if !ok {
+				cells = append(cells, "")
+			} else if telnet_is_scalar(cell) {
+				cells = append(cells, telnet_scalar(cell))
+			} else {
+				encoded, _ := json.Marshal(cell)
+				cells = append(cells, string(encoded))
+			}
+		}
+		fmt.Fprintln(table, pad + strings.Join(cells, "\t"))
+	}
+	table.Flush()
+}
+
+// telnet_rows returns val as table rows if it is a non-empty list of
+// maps.
+func telnet_rows(val interface{}) ([]map[string]interface{}, bool) {
+	var v = reflect.ValueOf(val)
+	if v.Kind() != reflect.Slice || v.Len() == 0 {
+		return nil, false
+	}
+
+	var rows []map[string]interface{}
+	for i := 0; i < v.Len(); i++ {
+		var item = reflect.ValueOf(v.Index(i).Interface())
+		if item.Kind() != reflect.Map {
+			return nil, false
+		}
+		var row = make(map[string]interface{})
+		for _, key := range telnet_map_keys(item) {
+			row[key] = item.MapIndex(reflect.ValueOf(key)).Interface()
+		}
+		rows = append(rows, row)
+	}
+	return rows, true
+}
+
+func telnet_map_keys(v reflect.Value) []string {
+	var keys []string
+	for _, key := range v.MapKeys() {
+		if key.Kind() == reflect.String {
+			keys = append(keys, key.String())
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func telnet_is_scalar(val interface{}) bool {
+	switch reflect.ValueOf(val).Kind() {
+	case reflect.Map, reflect.Slice, reflect.Array:
+		return reflect.ValueOf(val).Len() == 0
+	}
+	return true
+}
+
+// telnet_scalar quotes strings only when they'd otherwise be
+// ambiguous.
+func telnet_scalar(val interface{}) string {
+	switch val.(type) {
+	case nil:
+		return "null"
+	case string:
+		var str = val.(string)
+		if str == "" || str != strings.TrimSpace(str) ||
+			strings.ContainsAny(str, ":#\n\"'") || str == "null" ||
+			str == "true" || str == "false" {
+			encoded, _ := json.Marshal(str)
+			return string(encoded)
+		}
+		return str
+	}
+
+	var v = reflect.ValueOf(val)
+	if (v.Kind() == reflect.Map || v.Kind() == reflect.Slice) && v.Len() == 0 {
+		if v.Kind() == reflect.Map {
+			return "{}"
+		}
+		return "[]"
+	}
+	return fmt.Sprint(val)
+}
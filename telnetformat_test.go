@@ -0,0 +1,58 @@
+package goservice
+
+import (
+	"testing"
+)
+
+func TestTelnetFormat(t *testing.T) {
+	var data = APIData{
+		"name": "pizza",
+		"note": "a: b",
+		"tags": []interface{}{"hot", "cheesy"},
+		"slices": []interface{}{
+			APIData{"id": 1, "topping": "olive"},
+			APIData{"id": 2},
+		},
+	}
+
+	var cases = []struct {
+		format string
+		ok bool
+		errors []string
+		output string
+	}{
+		{"json", true, nil, `{"data":{"name":"pizza","note":"a: b","slices":[{"id":1,"topping":"olive"},{"id":2}],"tags":["hot","cheesy"]},"success":true}`},
+		{"yaml", true, nil, "OK\n" +
+			"name: pizza\n" +
+			"note: \"a: b\"\n" +
+			"slices:\n" +
+			"  -\n" +
+			"    id: 1\n" +
+			"    topping: olive\n" +
+			"  -\n" +
+			"    id: 2\n" +
+			"tags:\n" +
+			"  - hot\n" +
+			"  - cheesy"},
+		{"table", true, nil, "OK\n" +
+			"name: pizza\n" +
+			"note: \"a: b\"\n" +
+			"slices:\n" +
+			"  ID  TOPPING\n" +
+			"  1   olive\n" +
+			"  2   \n" +
+			"tags:\n" +
+			"  - hot\n" +
+			"  - cheesy"},
+		{"yaml", false, []string{"Missing argument: text"}, "FAILED (call error)\n- \"Missing argument: text\""},
+		{"table", false, []string{DeniedUnauthorized}, "FAILED (unauthorized)\n- Unauthorized"},
+		{"pretty", false, []string{DeniedForbidden}, "{\n  \"errors\": [\n    \"Forbidden\"\n  ],\n  \"reason\": \"forbidden\",\n  \"success\": false\n}"},
+	}
+
+	for _, c := range cases {
+		output, err := telnet_format(c.format, c.ok, c.errors, data)
+		if err != nil || output != c.output {
+			t.Errorf("%s: got %v\n%s\nexpected\n%s", c.format, err, output, c.output)
+		}
+	}
+}
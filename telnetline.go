@@ -0,0 +1,528 @@
+package goservice
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Telnet protocol bytes (RFC 854) and the options we negotiate.
+const (
+	telnetIAC = 255
+	telnetDONT = 254
+	telnetDO = 253
+	telnetWONT = 252
+	telnetWILL = 251
+	telnetSB = 250
+	telnetSE = 240
+
+	telnetOptEcho = 1
+	telnetOptSGA = 3
+	telnetOptNAWS = 31
+)
+
+const (
+	maxTelnetHistory = 100
+	maxTelnetSubnegotiation = 64
+)
+
+var errTelnetSubnegotiation = errors.New("telnet subnegotiation too long")
+
+// telnetLineReader reads command lines from a telnet connection. Once
+// the client agrees to let the server echo, it switches to character
+// mode and handles editing, history and completion itself; otherwise
+// the client's own line mode is trusted and lines are read as-is.
+//
+// The lock covers the line being edited and how it's shown, so that
+// printAbove can put output above it from another goroutine.
+type telnetLineReader struct {
+	reader *bufio.Reader
+	write func([]byte)
+	complete func(string) (string, []string)
+	modeChanged func(bool)
+
+	charMode bool
+	width int
+	history []string
+
+	line []rune
+	pos int
+	prompt string
+	secret bool
+	reading bool
+	lastCR bool
+
+	sync.Mutex
+}
+
+func newTelnetLineReader(reader io.Reader, write func([]byte)) *telnetLineReader {
+	return &telnetLineReader{
+		reader: bufio.NewReader(reader),
+		write: write,
+		width: 80,
+	}
+}
+
+// negotiate asks the client to let the server echo, to suppress
+// go-aheads and to report its window size.
+func (lr *telnetLineReader) negotiate() {
+	lr.write([]byte{
+		telnetIAC, telnetWILL, telnetOptEcho,
+		telnetIAC, telnetWILL, telnetOptSGA,
+		telnetIAC, telnetDO, telnetOptNAWS,
+	})
+}
+
+// ReadLine shows prompt and returns the next line without its line
+// ending, adding it to the history.
+func (lr *telnetLineReader) ReadLine(prompt string) (string, error) {
+	line, err := lr.read(prompt, false)
+	if err == nil && strings.TrimSpace(line) != "" {
+		if len(lr.history) == 0 || lr.history[len(lr.history)-1] != line {
+			lr.history = append(lr.history, line)
+			if len(lr.history) > maxTelnetHistory {
+				lr.history = lr.history[1:]
+			}
+		}
+	}
+	return line, err
+}
+
+// ReadPassword is ReadLine without echo or history. Clients in line
+// mode echo for themselves, so there it is only as private as they
+// make it.
+func (lr *telnetLineReader) ReadPassword(prompt string) (string, error) {
+	return lr.read(prompt, true)
+}
+
+func (lr *telnetLineReader) read(prompt string, secret bool) (string, error) {
+	lr.Lock()
+	lr.line, lr.pos, lr.prompt, lr.secret = nil, 0, prompt, secret
+	lr.reading = true
+	lr.write([]byte(prompt))
+	lr.Unlock()
+
+	defer func() {
+		lr.Lock()
+		lr.reading = false
+		lr.Unlock()
+	}()
+
+	var historyPos = len(lr.history)
+	var pending = ""
+	var raw []byte
+
+	for {
+		b, err := lr.readByte()
+		if err != nil {
+			return "", err
+		}
+
+		if b == '\r' || b == '\n' {
+			if b == '\n' && lr.lastCR {
+				lr.lastCR = false
+				continue
+			}
+			lr.lastCR = b == '\r'
+			if lr.charMode {
+				return lr.finish(), nil
+			}
+			return string(raw), nil
+		}
+		if b == 0 && lr.lastCR {
+			lr.lastCR = false
+			continue
+		}
+		lr.lastCR = false
+
+		if !lr.charMode {
+			raw = append(raw, b)
+			continue
+		}
+
+		// Read the rest of multi-byte keys before locking, so output
+		// never waits on a half-sent key.
+		var key rune = rune(b)
+		var escape byte
+		if b == 27 {
+			escape = lr.readEscape()
+		} else if b >= 32 {
+			key = lr.readRune(b)
+		}
+
+		lr.Lock()
+		var eof = lr.edit(key, escape, &historyPos, &pending)
+		lr.Unlock()
+
+		if eof {
+			return "", io.EOF
+		}
+	}
+}
+
+// finish ends the line in character mode, before anything else can
+// be printed after it.
+func (lr *telnetLineReader) finish() string {
+	lr.Lock()
+	defer lr.Unlock()
+	lr.reading = false
+	lr.write([]byte("\r\n"))
+	return string(lr.line)
+}
+
+// edit applies one key to the line, returning true for Ctrl-D on an
+// empty line.
+func (lr *telnetLineReader) edit(key rune, escape byte, historyPos *int, pending *string) bool {
+	switch key {
+	case 1: // Ctrl-A
+		lr.pos = 0
+	case 5: // Ctrl-E
+		lr.pos = len(lr.line)
+	case 3: // Ctrl-C
+		lr.write([]byte("^C\r\n"))
+		lr.line, lr.pos = nil, 0
+		lr.write([]byte(lr.prompt))
+		return false
+	case 4: // Ctrl-D
+		if len(lr.line) == 0 {
+			return true
+		}
+		lr.deleteAt(lr.pos)
+	case 8, 127: // Backspace
+		if lr.pos > 0 {
+			lr.pos--
+			lr.deleteAt(lr.pos)
+		}
+	case 11: // Ctrl-K
+		lr.line = lr.line[:lr.pos]
+	case 21: // Ctrl-U
+		lr.line = lr.line[lr.pos:]
+		lr.pos = 0
+	case 23: // Ctrl-W
+		var start = lr.pos
+		for start > 0 && lr.line[start-1] == ' ' {
+			start--
+		}
+		for start > 0 && lr.line[start-1] != ' ' {
+			start--
+		}
+		lr.line = append(lr.line[:start], lr.line[lr.pos:]...)
+		lr.pos = start
+	case 9: // Tab
+		if !lr.secret && lr.complete != nil {
+			lr.tabComplete()
+		}
+	case 27: // Escape sequence
+		switch escape {
+		case 'A':
+			if *historyPos > 0 {
+				if *historyPos == len(lr.history) {
+					*pending = string(lr.line)
+				}
+				*historyPos--
+				lr.setLine(lr.history[*historyPos])
+			}
+		case 'B':
+			if *historyPos < len(lr.history) {
+				*historyPos++
+				if *historyPos == len(lr.history) {
+					lr.setLine(*pending)
+				} else {
+					lr.setLine(lr.history[*historyPos])
+				}
+			}
+		case 'C':
+			if lr.pos < len(lr.line) {
+				lr.pos++
+			}
+		case 'D':
+			if lr.pos > 0 {
+				lr.pos--
+			}
+		case 'H':
+			lr.pos = 0
+		case 'F':
+			lr.pos = len(lr.line)
+		case '~': // Delete
+			lr.deleteAt(lr.pos)
+		}
+	default:
+		if key < 32 {
+			return false
+		}
+		lr.insert([]rune{key})
+	}
+
+	if !lr.secret {
+		lr.redraw()
+	}
+	return false
+}
+
+// printAbove writes the output render returns. At a prompt, that
+// goes on its own line(s) above the prompt, which is redrawn with
+// whatever had been typed; in line mode the client holds the typed
+// text, so only the prompt can be shown again.
+func (lr *telnetLineReader) printAbove(render func() []byte) {
+	lr.Lock()
+	defer lr.Unlock()
+
+	var out = render()
+	if !lr.reading {
+		lr.write(out)
+		return
+	}
+
+	if !lr.charMode {
+		if lr.prompt != "" {
+			out = append([]byte("\n"), out...)
+		}
+		lr.write(append(out, lr.prompt...))
+		return
+	}
+
+	lr.write(append([]byte("\r\x1b[K"), out...))
+	if lr.secret {
+		lr.write([]byte(lr.prompt))
+	} else {
+		lr.redraw()
+	}
+}
+
+// readByte returns the next byte of input, handling any telnet
+// commands on the way.
+func (lr *telnetLineReader) readByte() (byte, error) {
+	for {
+		b, err := lr.reader.ReadByte()
+		if err != nil || b != telnetIAC {
+			return b, err
+		}
+
+		cmd, err := lr.reader.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+
+		switch cmd {
+		case telnetIAC:
+			return telnetIAC, nil
+		case telnetDO, telnetDONT, telnetWILL, telnetWONT:
+			option, err := lr.reader.ReadByte()
+			if err != nil {
+				return 0, err
+			}
+			if option == telnetOptEcho && (cmd == telnetDO || cmd == telnetDONT) {
+				lr.Lock()
+				lr.charMode = cmd == telnetDO
+				if lr.modeChanged != nil {
+					lr.modeChanged(lr.charMode)
+				}
+				lr.Unlock()
+			}
+		case telnetSB:
+			if err := lr.readSubnegotiation(); err != nil {
+				return 0, err
+			}
+		}
+	}
+}
+
+// readSubnegotiation reads up to IAC SE. The only one we ask for,
+// NAWS, is five bytes, so anything much longer drops the connection.
+func (lr *telnetLineReader) readSubnegotiation() error {
+	var data []byte
+	for {
+		if len(data) > maxTelnetSubnegotiation {
+			return errTelnetSubnegotiation
+		}
+		b, err := lr.reader.ReadByte()
+		if err != nil {
+			return err
+		}
+		if b == telnetIAC {
+			next, err := lr.reader.ReadByte()
+			if err != nil {
+				return err
+			}
+			if next == telnetSE {
+				break
+			}
+			b = next
+		}
+		data = append(data, b)
+	}
+
+	if len(data) == 5 && data[0] == telnetOptNAWS {
+		var width = int(data[1]) << 8 | int(data[2])
+		if width > 0 {
+			lr.width = width
+		}
+	}
+	return nil
+}
+
+// readEscape reads the rest of an ANSI escape sequence and returns its
+// final byte. Keys sent as ESC [ n ~ come back as 'H' (Home), 'F'
+// (End) or '~' (Delete), or 0 for the ones not handled.
+func (lr *telnetLineReader) readEscape() byte {
+	b, err := lr.readByte()
+	if err != nil || (b != '[' && b != 'O') {
+		return 0
+	}
+
+	// Only the first parameter matters; any after a ';' are modifiers
+	var param, params = 0, 0
+	for {
+		b, err = lr.readByte()
+		if err != nil {
+			return 0
+		}
+		if b == ';' {
+			params++
+			continue
+		}
+		if b < '0' || b > '9' {
+			break
+		}
+		if params == 0 && param < 1000 {
+			param = param * 10 + int(b - '0')
+		}
+	}
+
+	if b != '~' {
+		return b
+	}
+	switch param {
+	case 1, 7:
+		return 'H'
+	case 4, 8:
+		return 'F'
+	case 3:
+		return '~'
+	}
+	return 0
+}
+
+// readRune finishes reading a UTF-8 character starting with first.
+func (lr *telnetLineReader) readRune(first byte) rune {
+	if first < utf8.RuneSelf {
+		return rune(first)
+	}
+
+	var buf = []byte{first}
+	for !utf8.FullRune(buf) && len(buf) < utf8.UTFMax {
+		b, err := lr.readByte()
+		if err != nil {
+			break
+		}
+		buf = append(buf, b)
+	}
+	r, _ := utf8.DecodeRune(buf)
+	return r
+}
+
+func (lr *telnetLineReader) insert(text []rune) {
+	var line = make([]rune, 0, len(lr.line)+len(text))
+	line = append(line, lr.line[:lr.pos]...)
+	line = append(line, text...)
+	lr.line = append(line, lr.line[lr.pos:]...)
+	lr.pos += len(text)
+}
+
+func (lr *telnetLineReader) deleteAt(pos int) {
+	if pos < len(lr.line) {
+		lr.line = append(lr.line[:pos], lr.line[pos+1:]...)
+	}
+}
+
+func (lr *telnetLineReader) setLine(line string) {
+	lr.line = []rune(line)
+	lr.pos = len(lr.line)
+}
+
+// redraw rewrites the prompt and line and puts the cursor back.
+func (lr *telnetLineReader) redraw() {
+	var out = "\r" + lr.prompt + string(lr.line) + "\x1b[K"
+	if back := len(lr.line) - lr.pos; back > 0 {
+		out += "\x1b[" + strconv.Itoa(back) + "D"
+	}
+	lr.write([]byte(out))
+}
+
+// tabComplete completes the word before the cursor as far as all the
+// candidates agree, listing them if that gets no further.
+func (lr *telnetLineReader) tabComplete() {
+	word, candidates := lr.complete(string(lr.line[:lr.pos]))
+	if len(candidates) == 0 {
+		return
+	}
+
+	if len(candidates) == 1 {
+		var rest = candidates[0][len(word):]
+		if !strings.HasSuffix(rest, "=") {
+			rest += " "
+		}
+		lr.insert([]rune(rest))
+		return
+	}
+
+	var prefix = commonPrefix(candidates)
+	if len(prefix) > len(word) {
+		lr.insert([]rune(prefix[len(word):]))
+		return
+	}
+
+	lr.write([]byte("\r\n" + lr.columns(candidates)))
+}
+
+func (lr *telnetLineReader) columns(words []string) string {
+	var widest = 0
+	for _, word := range words {
+		if len(word) > widest {
+			widest = len(word)
+		}
+	}
+	widest += 2
+
+	var perLine = lr.width / widest
+	if perLine < 1 {
+		perLine = 1
+	}
+
+	var out = ""
+	for i, word := range words {
+		out += word
+		if (i+1) % perLine == 0 || i == len(words)-1 {
+			out += "\r\n"
+		} else {
+			out += strings.Repeat(" ", widest-len(word))
+		}
+	}
+	return out
+}
+
+func commonPrefix(words []string) string {
+	var prefix = words[0]
+	for _, word := range words[1:] {
+		for !strings.HasPrefix(word, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix
+}
+
+func matching(words []string, prefix string) []string {
+	var matches []string
+	for _, word := range words {
+		if strings.HasPrefix(word, prefix) {
+			matches = append(matches, word)
+		}
+	}
+	sort.Strings(matches)
+	return matches
+}
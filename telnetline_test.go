@@ -0,0 +1,55 @@
+package goservice
+
+import (
+	"strings"
+	"testing"
+)
+
+// readTelnetLine feeds input to a line reader in character mode and
+// returns the line it reads.
+func readTelnetLine(input string) (string, *telnetLineReader, error) {
+	var lr = newTelnetLineReader(strings.NewReader(input), func([]byte) {})
+	lr.charMode = true
+	line, err := lr.ReadLine("> ")
+	return line, lr, err
+}
+
+func TestTelnetLineEditing(t *testing.T) {
+	var cases = []struct {
+		input string
+		line string
+	}{
+		{"abc\r", "abc"},
+		{"abc\x7f\x7fx\r", "ax"},
+		{"abc\x1b[D\x1b[DX\r", "aXbc"},
+		{"abc\x1b[D\x1b[D\x1b[3~\r", "ac"},
+		{"abc\x1b[1~X\x1b[4~Y\r", "XabcY"},
+		{"abc\x1b[7~X\x1b[8~Y\r", "XabcY"},
+		{"abc\x1b[HX\x1b[FY\r", "XabcY"},
+		{"abc\x1bOHX\r", "Xabc"},
+		{"abc\x1b[D\x1b[2~\x1b[5~\x1b[6~\r", "abc"},
+		{"abc\x1b[1;5D\r", "abc"},
+		{"abc\x01\x0b\r", ""},
+	}
+
+	for _, c := range cases {
+		line, _, err := readTelnetLine(c.input)
+		if err != nil || line != c.line {
+			t.Errorf("%q: got %q, %v, expected %q", c.input, line, err, c.line)
+		}
+	}
+}
+
+func TestTelnetSubnegotiation(t *testing.T) {
+	var naws = string([]byte{telnetIAC, telnetSB, telnetOptNAWS, 0, 120, 0, 40, telnetIAC, telnetSE})
+	line, lr, err := readTelnetLine(naws + "hi\r")
+	if err != nil || line != "hi" || lr.width != 120 {
+		t.Errorf("Got %q, %v, width %d", line, err, lr.width)
+	}
+
+	// Nothing is buffered without limit before login
+	var endless = string([]byte{telnetIAC, telnetSB, telnetOptNAWS}) + strings.Repeat("x", 100000) + "\r"
+	if _, _, err := readTelnetLine(endless); err != errTelnetSubnegotiation {
+		t.Errorf("Oversized subnegotiation: %v", err)
+	}
+}
@@ -0,0 +1,165 @@
+package goservice
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// telnetToken is one word of a telnet command line. Key is set for
+// key=value words, where the key came before any quoting.
+type telnetToken struct {
+	Key string
+	Value string
+}
+
+// telnet_tokenize splits a line the way a shell would: single quotes
+// are literal, double quotes allow backslash escapes, and a backslash
+// outside quotes escapes the next character. Adjacent quoted and
+// unquoted parts join into one word.
+func telnet_tokenize(line string) ([]telnetToken, error) {
+	var tokens []telnetToken
+	var current []rune
+	var inWord, quoted = false, false
+	var key = ""
+	var haveKey = false
+
+	var finish = func() {
+		if inWord {
+			tokens = append(tokens, telnetToken{Key: key, Value: string(current)})
+		}
+		current, inWord, quoted, key, haveKey = nil, false, false, "", false
+	}
+
+	var runes = []rune(line)
+	for i := 0; i < len(runes); i++ {
+		var r = runes[i]
+		switch {
+		case r == ' ' || r == '\t':
+			finish()
+
+		case r == '\\':
+			if i+1 >= len(runes) {
+				return nil, errors.New("Trailing backslash")
+			}
+			i++
+			current = append(current, runes[i])
+			inWord, quoted = true, true
+
+		case r == '\'':
+			var end = strings.IndexRune(string(runes[i+1:]), '\'')
+			if end < 0 {
+				return nil, errors.New("Unterminated quote")
+			}
+			var literal = []rune(string(runes[i+1:])[:end])
+			current = append(current, literal...)
+			i += len(literal) + 1
+			inWord, quoted = true, true
+
+		case r == '"':
+			i++
+			for ; i < len(runes) && runes[i] != '"'; i++ {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+				}
+				current = append(current, runes[i])
+			}
+			if i >= len(runes) {
+				return nil, errors.New("Unterminated quote")
+			}
+			inWord, quoted = true, true
+
+		case r == '=' && !quoted && !haveKey && inWord && isArgName(string(current)):
+			key, haveKey = string(current), true
+			current = nil
+
+		default:
+			current = append(current, r)
+			inWord = true
+		}
+	}
+	finish()
+
+	return tokens, nil
+}
+
+func isArgName(name string) bool {
+	for i, r := range name {
+		var letter = r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
+		if !letter && (i == 0 || r < '0' || r > '9') {
+			return false
+		}
+	}
+	return name != ""
+}
+
+// telnet_map_args fills a method's arguments from key=value tokens
+// first, then positional tokens into the remaining ArgSpec slots in
+// order. NestedArg values, and RawArg values that look like JSON,
+// are decoded as inline JSON.
+func telnet_map_args(method *APIMethod, tokens []telnetToken) (APIData, error) {
+	var args = make(APIData)
+	var specs = make(map[string]APIArg)
+	for _, arg := range method.ArgSpec {
+		specs[arg.Name] = arg
+	}
+
+	var positional []string
+	for _, token := range tokens {
+		if token.Key == "" {
+			positional = append(positional, token.Value)
+			continue
+		}
+
+		arg, ok := specs[token.Key]
+		if !ok {
+			return nil, fmt.Errorf("Unknown argument '%s'", token.Key)
+		}
+		if _, given := args[token.Key]; given {
+			return nil, fmt.Errorf("Argument '%s' given twice", token.Key)
+		}
+
+		val, err := telnet_arg_value(arg, token.Value)
+		if err != nil {
+			return nil, err
+		}
+		args[token.Key] = val
+	}
+
+	for _, arg := range method.ArgSpec {
+		if len(positional) == 0 {
+			break
+		}
+		if _, given := args[arg.Name]; given {
+			continue
+		}
+
+		val, err := telnet_arg_value(arg, positional[0])
+		if err != nil {
+			return nil, err
+		}
+		args[arg.Name] = val
+		positional = positional[1:]
+	}
+
+	if len(positional) > 0 {
+		return nil, errors.New("Too many arguments")
+	}
+	return args, nil
+}
+
+func telnet_arg_value(arg APIArg, value string) (interface{}, error) {
+	var trimmed = strings.TrimSpace(value)
+	var looksJSON = strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[")
+
+	if arg.ArgType != NestedArg && !(arg.ArgType == RawArg && looksJSON) {
+		return value, nil
+	}
+
+	var decoded interface{}
+	if err := json.Unmarshal([]byte(value), &decoded); err != nil {
+		return nil, fmt.Errorf("Invalid JSON for %s: %v", arg.Name, err)
+	}
+	return ToAPIData(decoded), nil
+}
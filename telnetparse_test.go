@@ -0,0 +1,42 @@
+package goservice
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTelnetTokenize(t *testing.T) {
+	var cases = []struct {
+		line string
+		tokens []telnetToken
+	}{
+		{"", nil},
+		{"  users   get ", []telnetToken{{"", "users"}, {"", "get"}}},
+		{"get id=42", []telnetToken{{"", "get"}, {"id", "42"}}},
+		{`say text='it''s' x="a \"b\""`, []telnetToken{{"", "say"}, {"text", "its"}, {"x", `a "b"`}}},
+		{`say 'a b' c\ d`, []telnetToken{{"", "say"}, {"", "a b"}, {"", "c d"}}},
+		{`'id'=42 a=b=c`, []telnetToken{{"", "id=42"}, {"a", "b=c"}}},
+		{`1x=2 =3`, []telnetToken{{"", "1x=2"}, {"", "=3"}}},
+		{`empty=''`, []telnetToken{{"empty", ""}}},
+		{`data='{"a": 1}'`, []telnetToken{{"data", `{"a": 1}`}}},
+	}
+
+	for _, c := range cases {
+		tokens, err := telnet_tokenize(c.line)
+		if err != nil {
+			t.Errorf("%q: %v", c.line, err)
+			continue
+		}
+		if !reflect.DeepEqual(tokens, c.tokens) {
+			t.Errorf("%q: expected %v, got %v", c.line, c.tokens, tokens)
+		}
+	}
+}
+
+func TestTelnetTokenizeErrors(t *testing.T) {
+	for _, line := range []string{`say 'oops`, `say "oops`, `say oops\`} {
+		if _, err := telnet_tokenize(line); err == nil {
+			t.Errorf("%q: expected an error", line)
+		}
+	}
+}
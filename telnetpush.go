@@ -0,0 +1,149 @@
+package goservice
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// TelnetSessionConnection delivers anything sent to a telnet session
+// from its own goroutine, so senders never wait on the client. While
+// the user is at the prompt, messages are printed above the line
+// being edited, which is then redrawn.
+type TelnetSessionConnection struct {
+	conn net.Conn
+	info ConnectionInfo
+	crlf bool
+	console *telnetConnection
+	queue []func() []byte
+	notify chan bool
+	closed bool
+	writeLock sync.Mutex
+	sync.Mutex
+}
+
+const maxQueuedTelnetMessages = 1000
+
+func newTelnetSessionConnection(tc *telnetConnection) *TelnetSessionConnection {
+	return &TelnetSessionConnection{
+		conn: tc.conn,
+		info: ConnectionInfo{
+			Endpoint: "telnet",
+			RemoteAddr: telnet_remote_addr(tc.conn),
+		},
+		console: tc,
+		notify: make(chan bool, 1),
+	}
+}
+
+func (sessConn *TelnetSessionConnection) Send(msg []byte) {
+	sessConn.enqueue(func() []byte {
+		return sessConn.console.renderPush(msg, nil)
+	})
+}
+
+func (sessConn *TelnetSessionConnection) Push(data APIData) {
+	msg, err := json.Marshal(data)
+	if err != nil {
+		fmt.Printf("Telnet push encode error: %v\n", err)
+		return
+	}
+	sessConn.enqueue(func() []byte {
+		return sessConn.console.renderPush(msg, data)
+	})
+}
+
+func (sessConn *TelnetSessionConnection) Close() {
+	sessConn.Lock()
+	defer sessConn.Unlock()
+
+	if sessConn.closed {
+		return
+	}
+	sessConn.closed = true
+	sessConn.queue = nil
+	close(sessConn.notify)
+	sessConn.conn.Close()
+}
+
+func (sessConn *TelnetSessionConnection) Info() ConnectionInfo {
+	return sessConn.info
+}
+
+// enqueue adds output to be rendered and shown when the delivery
+// goroutine gets to it, dropping the oldest if the client has fallen
+// too far behind.
+func (sessConn *TelnetSessionConnection) enqueue(render func() []byte) {
+	sessConn.Lock()
+	defer sessConn.Unlock()
+
+	if sessConn.closed {
+		return
+	}
+
+	if len(sessConn.queue) >= maxQueuedTelnetMessages {
+		sessConn.queue = sessConn.queue[1:]
+	}
+	sessConn.queue = append(sessConn.queue, render)
+
+	select {
+	case sessConn.notify <- true:
+	default:
+	}
+}
+
+// deliver shows queued output until the connection closes. It starts
+// once the connection's line reader exists.
+func (sessConn *TelnetSessionConnection) deliver() {
+	for range sessConn.notify {
+		sessConn.Lock()
+		var queue = sessConn.queue
+		sessConn.queue = nil
+		sessConn.Unlock()
+
+		for _, render := range queue {
+			sessConn.console.lines.printAbove(render)
+		}
+	}
+}
+
+// write sends data to the client, with CRLF line endings once the
+// client is in character mode and no longer translates them itself.
+func (sessConn *TelnetSessionConnection) write(data []byte) {
+	sessConn.writeLock.Lock()
+	defer sessConn.writeLock.Unlock()
+	if sessConn.crlf {
+		data = bytes.Replace(bytes.Replace(data, []byte("\r\n"), []byte("\n"), -1), []byte("\n"), []byte("\r\n"), -1)
+	}
+	sessConn.conn.Write(data)
+}
+
+func (sessConn *TelnetSessionConnection) setCRLF(crlf bool) {
+	sessConn.writeLock.Lock()
+	sessConn.crlf = crlf
+	sessConn.writeLock.Unlock()
+}
+
+
+// renderPush shows a message sent or pushed to the session: as
+// {"push": message} in script mode, otherwise as-is, or as
+// "[topic] data" for topic messages. Called with the line reader
+// locked, like everything that renders queued output.
+func (tc *telnetConnection) renderPush(msg []byte, data APIData) []byte {
+	if tc.script {
+		var message interface{} = string(msg)
+		if json.Valid(msg) {
+			message = json.RawMessage(msg)
+		}
+		encoded, _ := json.Marshal(APIData{"push": message})
+		return append(encoded, '\n')
+	}
+
+	if topic, ok := data["topic"].(string); ok {
+		encoded, _ := json.Marshal(data["data"])
+		return []byte(fmt.Sprintf("[%s] %s\n", topic, encoded))
+	}
+	return append(msg, '\n')
+}
@@ -0,0 +1,142 @@
+package goservice
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+)
+
+// Script mode is for cron jobs and CI scripts rather than people:
+// there's no prompt, and each command line gets exactly one line of
+// JSON back. That's the response envelope for method calls, or
+// {"success": true, "output": "..."} for console commands, with a
+// "status" added that is zero only on success:
+//
+//   0  success
+//   1  the method failed
+//   2  call error (bad command, arguments, unknown method, ...)
+//   3  unauthorized or forbidden
+//
+// Messages pushed to the session arrive in between as {"push": ...}
+// lines, and results of "watch" calls as response lines with a
+// "watch" id.
+const (
+	ScriptOK = 0
+	ScriptFailed = 1
+	ScriptCallError = 2
+	ScriptDenied = 3
+)
+
+// NewTelnetUnixEndpoint is a TelnetEndpoint listening on a unix
+// socket at path, for scripts on the same machine. Whoever can open
+// the socket gets a console, so set its directory's permissions, or
+// Authenticators, accordingly.
+func NewTelnetUnixEndpoint(path string, context ServerContext) *TelnetEndpoint {
+	var endpoint = NewTelnetEndpoint(path, context)
+	endpoint.Network = "unix"
+	endpoint.logPrefix = "Telnet unix:" + path
+	return endpoint
+}
+
+// telnet_remove_socket removes a stale socket file left at path by
+// an earlier run. Anything else found there is an error, and is left
+// alone.
+func telnet_remove_socket(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode() & os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+	return os.Remove(path)
+}
+
+func telnet_command_script(console TelnetConsole, args []string) {
+	var tc = console.(*telnetConnection)
+	if tc.script {
+		return
+	}
+
+	// Whatever the client has already seen (banner, prompt) ends
+	// here, so the acknowledgement starts on a line of its own.
+	tc.lines.Lock()
+	tc.script = true
+	tc.lines.Unlock()
+	tc.sessConn.write([]byte("\n"))
+	tc.writeResult(APIData{"success": true, "output": "script mode\n"})
+}
+
+// runScripted runs one command line, capturing its output, and
+// writes the result as a single JSON line.
+func (tc *telnetConnection) runScripted(line string) {
+	tc.capture, tc.result = new(bytes.Buffer), nil
+	tc.handle(line)
+
+	var result = tc.result
+	if result == nil {
+		result = APIData{"success": true, "output": tc.capture.String()}
+	}
+	tc.capture, tc.result = nil, nil
+
+	tc.writeResult(result)
+}
+
+// fail reports an error that stops a command before (or instead of)
+// making a call.
+func (tc *telnetConnection) fail(message string) {
+	if tc.script {
+		tc.result = ErrorResponse([]string{message})
+		return
+	}
+	tc.WriteLinef("%s", message)
+}
+
+func (tc *telnetConnection) writeResult(result APIData) {
+	tc.sessConn.write(telnet_script_line(result))
+}
+
+// telnet_script_line encodes a result, with its status, as one line.
+func telnet_script_line(result APIData) []byte {
+	result["status"] = telnet_script_status(result)
+
+	encoded, err := json.Marshal(result)
+	if err != nil {
+		encoded, _ = json.Marshal(APIData{
+			"success": false,
+			"reason": "call error",
+			"errors": []string{"Error encoding response: " + err.Error()},
+			"status": ScriptCallError,
+		})
+	}
+	return append(encoded, '\n')
+}
+
+func telnet_script_status(result APIData) int {
+	if success, _ := result["success"].(bool); success {
+		return ScriptOK
+	}
+
+	switch result["reason"] {
+	case "failure":
+		return ScriptFailed
+	case "unauthorized", "forbidden":
+		return ScriptDenied
+	}
+	return ScriptCallError
+}
+
+// telnet_remote_addr names the far end of conn for logs and
+// ConnectionInfo; unix socket peers are usually unnamed.
+func telnet_remote_addr(conn net.Conn) string {
+	var addr = conn.RemoteAddr()
+	if addr == nil || addr.String() == "" {
+		return conn.LocalAddr().Network()
+	}
+	return addr.String()
+}
@@ -0,0 +1,95 @@
+package goservice
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTelnetScriptStatus(t *testing.T) {
+	var endpoint = testTelnetEndpoint(t, testAPI(), nil)
+	defer endpoint.Stop()
+	var client = scriptTelnet(t, endpoint)
+	defer client.Close()
+
+	var cases = []struct {
+		line string
+		status int
+	}{
+		{"test echo text=hi", ScriptOK},
+		{"format json", ScriptOK},
+		{"test fail", ScriptFailed},
+		{"test nope", ScriptCallError},
+		{"test echo", ScriptCallError},
+		{"test echo text='unterminated", ScriptCallError},
+		{"test whoami", ScriptDenied},
+		{"test admin", ScriptDenied},
+	}
+
+	for _, c := range cases {
+		var result = client.run(c.line)
+		if result["status"] != float64(c.status) {
+			t.Errorf("%s: expected status %d, got %v", c.line, c.status, result)
+		}
+	}
+
+	var session = endpoint.context.Sessions().All()[0]
+	session.Push(APIData{"hello": "there"})
+	session.Send([]byte("not json"))
+	if push, _ := client.next()["push"].(APIData); push["hello"] != "there" {
+		t.Errorf("Wrong push: %v", push)
+	}
+	if push := client.next()["push"]; push != "not json" {
+		t.Errorf("Wrong push: %v", push)
+	}
+}
+
+func TestTelnetUnixEndpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "telnet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var harness = NewHarness(testAPI(), nil)
+
+	// Anything but a stale socket is left alone
+	var path = filepath.Join(dir, "file")
+	ioutil.WriteFile(path, nil, 0600)
+	if NewTelnetUnixEndpoint(path, harness.Server).Start() {
+		t.Errorf("Started over a regular file")
+	}
+
+	path = filepath.Join(dir, "console.sock")
+	var first = NewTelnetUnixEndpoint(path, harness.Server)
+	first.Start()
+	first.listener.(*net.UnixListener).SetUnlinkOnClose(false)
+	first.listener.Close()
+
+	var endpoint = NewTelnetUnixEndpoint(path, harness.Server)
+	endpoint.LineEditing = false
+	if !endpoint.Start() {
+		t.Fatalf("Didn't start over a stale socket")
+	}
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	var client = &telnetTestClient{t, conn, bufio.NewReader(conn)}
+	if result := client.run("script"); result["output"] != "script mode\n" {
+		t.Errorf("No script mode: %v", result)
+	}
+	if result := client.run("test echo text=hi"); result["data"].(APIData)["text"] != "hi" {
+		t.Errorf("Wrong response: %v", result)
+	}
+	client.Close()
+
+	endpoint.Stop()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Socket left behind: %v", err)
+	}
+}
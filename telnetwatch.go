@@ -0,0 +1,238 @@
+package goservice
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// telnetWatch is one of a connection's live subscriptions: either a
+// topic, whose messages arrive as pushes, or a method call re-run
+// every interval, with each result shown as it comes in. Topics are
+// subscribed through the "topics" service, so its restrictions apply.
+type telnetWatch struct {
+	id int
+	topic string
+	call string
+	interval time.Duration
+	stop chan bool
+}
+
+const minWatchInterval = time.Second
+
+func (w *telnetWatch) String() string {
+	if w.topic != "" {
+		return "topic " + w.topic
+	}
+	return fmt.Sprintf("%s (every %s)", w.call, w.interval)
+}
+
+func telnet_command_watch(console TelnetConsole, args []string) {
+	var tc = console.(*telnetConnection)
+
+	if len(args) == 0 {
+		if len(tc.watches) == 0 {
+			tc.WriteLinef("Not watching anything")
+			return
+		}
+		for _, id := range tc.watchIDs() {
+			tc.WriteLinef("  %d  %s", id, tc.watches[id])
+		}
+		return
+	}
+
+	if args[0] == "topic" {
+		if len(args) == 1 {
+			tc.fail("Usage: watch topic <topic>...")
+			return
+		}
+		for _, topic := range args[1:] {
+			if tc.watchingTopic(topic) {
+				tc.WriteLinef("Already watching topic %s", topic)
+				continue
+			}
+			ok, errors, response := tc.endpoint.context.API().HandleCall(
+				"topics", "subscribe", APIData{"topic": topic}, tc.session, tc.endpoint.context)
+			if !ok {
+				telnet_show_response(tc, ok, errors, response)
+				return
+			}
+			var w = tc.addWatch(&telnetWatch{topic: topic})
+			tc.WriteLinef("%d: watching topic %s", w.id, topic)
+		}
+		return
+	}
+
+	seconds, err := strconv.ParseFloat(args[0], 64)
+	if err != nil || len(args) < 2 {
+		tc.fail("Usage: " + tc.endpoint.commands["watch"].Usage)
+		return
+	}
+	var interval = time.Duration(seconds * float64(time.Second))
+	if interval < minWatchInterval {
+		tc.fail(fmt.Sprintf("Interval must be at least %s", minWatchInterval))
+		return
+	}
+
+	var tokens = tc.tokens[2:]
+	serviceName, methodName, callArgs, ok := telnet_call_args(tc, tokens)
+	if !ok {
+		return
+	}
+
+	var words []string
+	for _, token := range tokens {
+		if token.Key != "" {
+			words = append(words, token.Key + "=" + token.Value)
+		} else {
+			words = append(words, token.Value)
+		}
+	}
+	var w = tc.addWatch(&telnetWatch{
+		call: strings.Join(words, " "),
+		interval: interval,
+		stop: make(chan bool),
+	})
+	go tc.poll(w, serviceName, methodName, callArgs)
+	tc.WriteLinef("%d: watching %s", w.id, w)
+}
+
+func telnet_command_unwatch(console TelnetConsole, args []string) {
+	var tc = console.(*telnetConnection)
+
+	if len(args) == 0 {
+		tc.fail("Usage: " + tc.endpoint.commands["unwatch"].Usage)
+		return
+	}
+
+	if len(args) == 1 && args[0] == "all" {
+		var count = len(tc.watches)
+		tc.unwatchAll()
+		tc.WriteLinef("Stopped %d watch(es)", count)
+		return
+	}
+
+	for _, arg := range args {
+		id, _ := strconv.Atoi(arg)
+		if !tc.unwatch(id) {
+			tc.WriteLinef("%s: no such watch", arg)
+			continue
+		}
+		tc.WriteLinef("%s: stopped", arg)
+	}
+}
+
+func telnet_complete_unwatch(console TelnetConsole, args []string, word string) []string {
+	var tc = console.(*telnetConnection)
+	var candidates = []string{"all"}
+	for _, id := range tc.watchIDs() {
+		candidates = append(candidates, strconv.Itoa(id))
+	}
+	return candidates
+}
+
+
+// ------------------------------------
+// Watches
+// ------------------------------------
+
+// Watches are added and removed only by the connection's own
+// goroutine, while running commands or closing.
+
+func (tc *telnetConnection) addWatch(w *telnetWatch) *telnetWatch {
+	if tc.watches == nil {
+		tc.watches = make(map[int]*telnetWatch)
+	}
+	tc.lastWatch++
+	w.id = tc.lastWatch
+	tc.watches[w.id] = w
+	return w
+}
+
+func (tc *telnetConnection) unwatch(id int) bool {
+	w, ok := tc.watches[id]
+	if !ok {
+		return false
+	}
+	delete(tc.watches, id)
+
+	if w.topic != "" {
+		tc.endpoint.context.API().HandleCall(
+			"topics", "unsubscribe", APIData{"topic": w.topic}, tc.session, tc.endpoint.context)
+	} else {
+		close(w.stop)
+	}
+	return true
+}
+
+func (tc *telnetConnection) unwatchAll() {
+	for id := range tc.watches {
+		tc.unwatch(id)
+	}
+}
+
+func (tc *telnetConnection) watchingTopic(topic string) bool {
+	for _, w := range tc.watches {
+		if w.topic == topic {
+			return true
+		}
+	}
+	return false
+}
+
+func (tc *telnetConnection) watchIDs() []int {
+	var ids []int
+	for id := range tc.watches {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// poll makes a watch's call straight away and then every interval,
+// queueing each result for display, until the watch is stopped.
+func (tc *telnetConnection) poll(w *telnetWatch, serviceName string, methodName string, args APIData) {
+	var ticker = time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	var api = tc.endpoint.context.API()
+	for {
+		ok, errors, response := api.HandleCall(
+			serviceName, methodName, args, tc.session, tc.endpoint.context)
+
+		select {
+		case <-w.stop:
+			return
+		default:
+		}
+
+		tc.sessConn.enqueue(func() []byte {
+			return tc.renderWatch(w, ok, errors, response)
+		})
+
+		select {
+		case <-ticker.C:
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+// renderWatch shows one result of a watch: in script mode as its
+// response line with a "watch" id added, otherwise in the
+// connection's format under a line naming the watch.
+func (tc *telnetConnection) renderWatch(w *telnetWatch, ok bool, errors []string, response APIData) []byte {
+	if tc.script {
+		var result = Response(ok, errors, response)
+		result["watch"] = w.id
+		return telnet_script_line(result)
+	}
+
+	output, err := telnet_format(tc.format, ok, errors, response)
+	if err != nil {
+		output = "Error encoding response: " + err.Error()
+	}
+	return []byte(fmt.Sprintf("[%d] %s\n%s\n", w.id, w.call, output))
+}
@@ -0,0 +1,66 @@
+package goservice
+
+import (
+	"testing"
+)
+
+func TestTelnetWatchTopic(t *testing.T) {
+	var topics = NewTopicService()
+	topics.Restrict("subscribe")
+	var keys = NewAPIKeyAuthenticator(nil)
+	keys.AddKey("abc", "alice")
+
+	var api = testAPI().(*ServiceCollection)
+	api.AddService(topics)
+	api.AddService(NewAuthService(keys))
+
+	var endpoint = testTelnetEndpoint(t, api, nil)
+	defer endpoint.Stop()
+	var client = scriptTelnet(t, endpoint)
+	defer client.Close()
+
+	// Topic watches are subject to the topics service's restrictions
+	expectReason(t, client.run("watch topic news"), "unauthorized")
+	if count := endpoint.context.Publish("news", APIData{"n": 0}); count != 0 {
+		t.Fatalf("Subscribed without logging in")
+	}
+
+	client.run("auth login key=abc")
+	if result := client.run("watch topic news"); result["output"] != "1: watching topic news\n" {
+		t.Fatalf("Watch failed: %v", result)
+	}
+
+	endpoint.context.Publish("news", APIData{"n": 1})
+	var push, _ = client.next()["push"].(APIData)
+	if push["topic"] != "news" || push["data"].(APIData)["n"] != float64(1) {
+		t.Errorf("Wrong push: %v", push)
+	}
+
+	client.run("unwatch 1")
+	if count := endpoint.context.Publish("news", APIData{"n": 2}); count != 0 {
+		t.Errorf("Still subscribed after unwatch")
+	}
+}
+
+func TestTelnetWatchCall(t *testing.T) {
+	var endpoint = testTelnetEndpoint(t, testAPI(), nil)
+	defer endpoint.Stop()
+	var client = scriptTelnet(t, endpoint)
+	defer client.Close()
+
+	if result := client.run("watch 0.1 test echo text=hi"); result["success"] != false {
+		t.Errorf("Interval below the minimum accepted: %v", result)
+	}
+
+	if result := client.run("watch 1 test echo text=hi"); result["output"] != "1: watching test echo text=hi (every 1s)\n" {
+		t.Fatalf("Watch failed: %v", result)
+	}
+	var result = client.next()
+	if result["watch"] != float64(1) || result["data"].(APIData)["text"] != "hi" {
+		t.Errorf("Wrong watch result: %v", result)
+	}
+
+	if result := client.run("unwatch all"); result["output"] != "Stopped 1 watch(es)\n" {
+		t.Errorf("Unwatch failed: %v", result)
+	}
+}
@@ -0,0 +1,128 @@
+package goservice
+
+import (
+	"sync"
+)
+
+type topicRegistry struct {
+	topics map[string]map[string]Session
+	sync.RWMutex
+}
+
+func newTopicRegistry() *topicRegistry {
+	return &topicRegistry{
+		topics: make(map[string]map[string]Session),
+	}
+}
+
+func (registry *topicRegistry) subscribe(topic string, session Session) {
+	registry.Lock()
+	defer registry.Unlock()
+
+	subscribers, ok := registry.topics[topic]
+	if !ok {
+		subscribers = make(map[string]Session)
+		registry.topics[topic] = subscribers
+	}
+	subscribers[session.ID()] = session
+}
+
+func (registry *topicRegistry) unsubscribe(topic string, session Session) {
+	registry.Lock()
+	defer registry.Unlock()
+
+	subscribers, ok := registry.topics[topic]
+	if !ok {
+		return
+	}
+	delete(subscribers, session.ID())
+	if len(subscribers) == 0 {
+		delete(registry.topics, topic)
+	}
+}
+
+func (registry *topicRegistry) unsubscribeAll(session Session) {
+	registry.Lock()
+	defer registry.Unlock()
+
+	for topic, subscribers := range registry.topics {
+		delete(subscribers, session.ID())
+		if len(subscribers) == 0 {
+			delete(registry.topics, topic)
+		}
+	}
+}
+
+func (registry *topicRegistry) subscribers(topic string) []Session {
+	registry.RLock()
+	defer registry.RUnlock()
+
+	var sessions = make([]Session, 0, len(registry.topics[topic]))
+	for _, session := range registry.topics[topic] {
+		sessions = append(sessions, session)
+	}
+	return sessions
+}
+
+
+// ------------------------------------------
+// Context API
+// ------------------------------------------
+
+func (server *Server) Subscribe(topic string, session Session) {
+	server.topics.subscribe(topic, session)
+}
+
+func (server *Server) Unsubscribe(topic string, session Session) {
+	server.topics.unsubscribe(topic, session)
+}
+
+// Publish pushes {"topic": topic, "data": data} to every subscribed
+// session, encoded for whichever transport each one is on. It returns
+// the number of sessions reached.
+func (server *Server) Publish(topic string, data APIData) int {
+	var message = APIData{
+		"topic": topic,
+		"data": data,
+	}
+
+	var sessions = server.topics.subscribers(topic)
+	for _, session := range sessions {
+		session.Push(message)
+	}
+	return len(sessions)
+}
+
+
+// ------------------------------------------
+// Built-in service
+// ------------------------------------------
+
+var topicArgSpec = []APIArg {
+	APIArg{Name: "topic", ArgType: StringArg},
+}
+
+// NewTopicService returns a "topics" service letting clients manage
+// their own subscriptions. Add it to the server's API to enable it.
+func NewTopicService() *Service {
+	var service = NewService("topics")
+	service.AddMethod("subscribe", topicArgSpec, topicSubscribe)
+	service.AddMethod("unsubscribe", topicArgSpec, topicUnsubscribe)
+	return service
+}
+
+func topicSubscribe(args APIData, session Session, context ServerContext) (bool, APIData) {
+	if session == nil {
+		return false, APIData{"message": "No session"}
+	}
+	context.Subscribe(args["topic"].(string), session)
+	return true, nil
+}
+
+func topicUnsubscribe(args APIData, session Session, context ServerContext) (bool, APIData) {
+	if session == nil {
+		return false, APIData{"message": "No session"}
+	}
+	context.Unsubscribe(args["topic"].(string), session)
+	return true, nil
+}
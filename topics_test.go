@@ -0,0 +1,38 @@
+package goservice
+
+import (
+	"testing"
+)
+
+func TestTopicService(t *testing.T) {
+	var api = NewServiceCollection()
+	api.AddService(NewTopicService())
+	var harness = NewHarness(api, nil)
+
+	session, conn := harness.Connect()
+	other, otherConn := harness.Connect()
+
+	harness.Call(session, "topics", "subscribe", APIData{"topic": "news"})
+	harness.Call(other, "topics", "subscribe", APIData{"topic": "news"})
+	if count := harness.Server.Publish("news", APIData{"n": 1}); count != 2 {
+		t.Errorf("Published to %d sessions", count)
+	}
+	if count := harness.Server.Publish("other", APIData{"n": 1}); count != 0 {
+		t.Errorf("Published to %d sessions without subscribers", count)
+	}
+
+	harness.Call(session, "topics", "unsubscribe", APIData{"topic": "news"})
+	harness.Server.Publish("news", APIData{"n": 2})
+
+	if pushed := conn.TakePushed(); len(pushed) != 1 {
+		t.Errorf("Unsubscribed session got %v", pushed)
+	}
+	if pushed := otherConn.TakePushed(); len(pushed) != 2 {
+		t.Errorf("Subscribed session got %v", pushed)
+	}
+
+	harness.Server.SessionClosed(other, "test")
+	if count := harness.Server.Publish("news", APIData{"n": 3}); count != 0 {
+		t.Errorf("Closed session still subscribed")
+	}
+}
@@ -0,0 +1,353 @@
+package goservice
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"unicode"
+)
+
+// GenerateTypeScript writes a TypeScript client for the websocket
+// msgpack protocol, built from the same service metadata that the
+// telnet help command reads. Service and method names that would
+// shadow the client's own members (e.g. a "call" service) get a
+// trailing underscore. Names that differ only in punctuation or case
+// (e.g. "get-user" and "get_user") would generate the same
+// identifiers, so they're an error.
+func GenerateTypeScript(api API, w io.Writer) error {
+	var buf = new(bytes.Buffer)
+
+	buf.WriteString(tsPreamble)
+
+	var services = api.GetServices()
+	var serviceNames = make([]string, 0, len(services))
+	for name, _ := range services {
+		serviceNames = append(serviceNames, name)
+	}
+	sort.Strings(serviceNames)
+
+	if err := tsCheckNames("Services", serviceNames, tsServiceClass, tsClientMembers); err != nil {
+		return err
+	}
+
+	for _, serviceName := range serviceNames {
+		if err := tsWriteService(buf, services[serviceName]); err != nil {
+			return err
+		}
+	}
+
+	buf.WriteString("export class Client extends BaseClient {\n")
+	for _, serviceName := range serviceNames {
+		fmt.Fprintf(buf, "  readonly %s = new %s(this);\n",
+			tsMember(serviceName, tsClientMembers), tsServiceClass(serviceName))
+	}
+	buf.WriteString("}\n")
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func tsWriteService(buf *bytes.Buffer, service APIService) error {
+	var methods = service.GetMethods()
+	var methodNames = make([]string, 0, len(methods))
+	for name, _ := range methods {
+		methodNames = append(methodNames, name)
+	}
+	sort.Strings(methodNames)
+
+	var exported = func(name string) string {
+		return tsIdentifier(name, true)
+	}
+	if err := tsCheckNames("Methods of " + service.Name(), methodNames, exported, tsServiceMembers); err != nil {
+		return err
+	}
+
+	var className = tsServiceClass(service.Name())
+
+	for _, methodName := range methodNames {
+		fmt.Fprintf(buf, "export interface %s%sArgs %s\n\n",
+			className, tsIdentifier(methodName, true),
+			tsArgType(methods[methodName].ArgSpec, ""))
+	}
+
+	fmt.Fprintf(buf, "export class %s {\n", className)
+	buf.WriteString("  constructor(private client: BaseClient) {}\n")
+	for _, methodName := range methodNames {
+		var argsType = className + tsIdentifier(methodName, true) + "Args"
+		var optional = ""
+		if tsAllOptional(methods[methodName].ArgSpec) {
+			optional = " = {}"
+		}
+		fmt.Fprintf(buf, "\n  %s(args: %s%s): Promise<any> {\n",
+			tsMember(methodName, tsServiceMembers), argsType, optional)
+		fmt.Fprintf(buf, "    return this.client.call(%q, %q, args);\n",
+			service.Name(), methodName)
+		buf.WriteString("  }\n")
+	}
+	buf.WriteString("}\n\n")
+	return nil
+}
+
+func tsArgType(argSpec []APIArg, indent string) string {
+	if len(argSpec) == 0 {
+		return "{}"
+	}
+
+	var buf = new(bytes.Buffer)
+	buf.WriteString("{\n")
+	for _, arg := range argSpec {
+		var optional = ""
+		if arg.Default != nil {
+			optional = "?"
+		}
+		fmt.Fprintf(buf, "%s  %q%s: %s;\n",
+			indent, arg.Name, optional, tsType(arg, indent+"  "))
+	}
+	buf.WriteString(indent + "}")
+	return buf.String()
+}
+
+func tsType(arg APIArg, indent string) string {
+	switch arg.ArgType {
+	case IntArg, UIntArg, FloatArg:
+		return "number"
+	case StringArg:
+		return "string"
+	case NestedArg:
+		if spec, ok := arg.Extra.([]APIArg); ok {
+			return tsArgType(spec, indent)
+		}
+	}
+	return "any"
+}
+
+func tsAllOptional(argSpec []APIArg) bool {
+	for _, arg := range argSpec {
+		if arg.Default == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Members of BaseClient, and of the generated service classes, which
+// service and method properties would otherwise shadow.
+var tsClientMembers = []string{
+	"constructor", "sessionId", "resumeToken", "socket", "nextId",
+	"pending", "pushHandlers", "topicHandlers", "url", "reconnect",
+	"open", "ready", "call", "onPush", "onTopic", "close", "receive",
+	"dispatch", "failPending",
+}
+
+var tsServiceMembers = []string{"constructor", "client"}
+
+// tsMember is tsIdentifier for a property, with an underscore added
+// if it would clash with one of reserved.
+func tsMember(name string, reserved []string) string {
+	var ident = tsIdentifier(name, false)
+	for _, member := range reserved {
+		if ident == member {
+			return ident + "_"
+		}
+	}
+	return ident
+}
+
+// tsCheckNames fails if two of names would generate the same type
+// name (by typeName) or property name.
+func tsCheckNames(kind string, names []string, typeName func(string) string, reserved []string) error {
+	var types = make(map[string]string)
+	var members = make(map[string]string)
+	for _, name := range names {
+		var typeIdent, member = typeName(name), tsMember(name, reserved)
+		if other, ok := types[typeIdent]; ok {
+			return fmt.Errorf("%s %q and %q both generate %s", kind, other, name, typeIdent)
+		}
+		if other, ok := members[member]; ok {
+			return fmt.Errorf("%s %q and %q both generate %s", kind, other, name, member)
+		}
+		types[typeIdent], members[member] = name, name
+	}
+	return nil
+}
+
+func tsServiceClass(name string) string {
+	return tsIdentifier(name, true) + "Service"
+}
+
+func tsIdentifier(name string, exported bool) string {
+	var out []rune
+	var upper = exported
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = len(out) > 0 || exported
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		out = append(out, r)
+	}
+
+	if len(out) == 0 {
+		return "_"
+	}
+	if unicode.IsDigit(out[0]) {
+		out = append([]rune{'_'}, out...)
+	}
+	return string(out)
+}
+
+const tsPreamble = `// Generated by go-service. Do not edit.
+
+import { encode, decode } from "@msgpack/msgpack";
+
+export class CallError extends Error {
+  constructor(public reason: string, public errors: any) {
+    super(reason);
+  }
+}
+
+export type PushHandler = (message: Uint8Array) => void;
+export type TopicHandler = (data: any, topic: string) => void;
+
+interface PendingCall {
+  resolve: (data: any) => void;
+  reject: (err: any) => void;
+}
+
+export class BaseClient {
+  sessionId: string | null = null;
+  resumeToken: string | null = null;
+
+  private socket!: WebSocket;
+  private nextId = 1;
+  private pending = new Map<number, PendingCall>();
+  private pushHandlers: PushHandler[] = [];
+  private topicHandlers = new Map<string, TopicHandler[]>();
+
+  constructor(private url: string) {
+    this.open(url);
+  }
+
+  // reconnect opens a new socket, resuming the previous session if the
+  // server still holds it.
+  reconnect(): Promise<void> {
+    let url = this.url;
+    if (this.resumeToken !== null) {
+      url += (url.indexOf("?") < 0 ? "?" : "&") + "resume=" + encodeURIComponent(this.resumeToken);
+    }
+    this.open(url);
+    return this.ready();
+  }
+
+  private open(url: string): void {
+    this.socket = new WebSocket(url);
+    this.socket.binaryType = "arraybuffer";
+    this.socket.onmessage = (event) => this.receive(new Uint8Array(event.data));
+    this.socket.onclose = () => this.failPending("connection closed");
+  }
+
+  ready(): Promise<void> {
+    if (this.socket.readyState === WebSocket.OPEN) {
+      return Promise.resolve();
+    }
+    return new Promise((resolve, reject) => {
+      this.socket.addEventListener("open", () => resolve());
+      this.socket.addEventListener("error", (err) => reject(err));
+    });
+  }
+
+  call(service: string, method: string, data: object): Promise<any> {
+    const id = this.nextId++;
+    return new Promise((resolve, reject) => {
+      this.pending.set(id, { resolve, reject });
+      this.socket.send(encode({ service, method, data, id }));
+    });
+  }
+
+  onPush(handler: PushHandler): void {
+    this.pushHandlers.push(handler);
+  }
+
+  onTopic(topic: string, handler: TopicHandler): void {
+    const handlers = this.topicHandlers.get(topic) || [];
+    handlers.push(handler);
+    this.topicHandlers.set(topic, handlers);
+  }
+
+  close(): void {
+    this.socket.close();
+  }
+
+  private receive(message: Uint8Array): void {
+    if (!this.dispatch(message)) {
+      this.pushHandlers.forEach((handler) => handler(message));
+    }
+  }
+
+  // dispatch handles replies ('a' prefix), published messages ('p')
+  // and session announcements ('s'). Anything else came from
+  // Session.Send, as did a frame that doesn't decode as its prefix
+  // says, since a sent message may start with the same byte.
+  private dispatch(message: Uint8Array): boolean {
+    if (message.length === 0) {
+      return false;
+    }
+
+    let decoded: any;
+    try {
+      decoded = decode(message.subarray(1));
+    } catch (err) {
+      return false;
+    }
+    if (decoded === null || typeof decoded !== "object") {
+      return false;
+    }
+
+    switch (message[0]) {
+      case 0x73:
+        if (typeof decoded.resume !== "string") {
+          return false;
+        }
+        this.sessionId = decoded.session;
+        this.resumeToken = decoded.resume;
+        return true;
+
+      case 0x70: {
+        if (typeof decoded.topic !== "string") {
+          return false;
+        }
+        const handlers = this.topicHandlers.get(decoded.topic) || [];
+        handlers.forEach((handler) => handler(decoded.data, decoded.topic));
+        return true;
+      }
+
+      case 0x61: {
+        if (typeof decoded.id !== "number") {
+          return false;
+        }
+        const call = this.pending.get(decoded.id);
+        if (call !== undefined) {
+          this.pending.delete(decoded.id);
+          if (decoded.success) {
+            call.resolve(decoded.data);
+          } else {
+            call.reject(new CallError(decoded.reason, decoded.errors));
+          }
+        }
+        return true;
+      }
+    }
+    return false;
+  }
+
+  private failPending(reason: string): void {
+    this.pending.forEach((call) => call.reject(new CallError(reason, null)));
+    this.pending.clear();
+  }
+}
+
+`
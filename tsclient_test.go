@@ -0,0 +1,93 @@
+package goservice
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func noop(args APIData, session Session, context ServerContext) (bool, APIData) {
+	return true, nil
+}
+
+func generateTypeScript(services ...*Service) (string, error) {
+	var api = NewServiceCollection()
+	for _, svc := range services {
+		api.AddService(svc)
+	}
+
+	var buf = new(bytes.Buffer)
+	var err = GenerateTypeScript(api, buf)
+	return buf.String(), err
+}
+
+func TestGenerateTypeScript(t *testing.T) {
+	var users = NewService("user-admin")
+	users.AddMethod("get_user", []APIArg{
+		APIArg{Name: "id", ArgType: IntArg},
+		APIArg{Name: "fields", ArgType: StringArg, Default: ""},
+	}, noop)
+	users.AddMethod("client", nil, noop)
+
+	var call = NewService("call")
+	call.AddMethod("list", nil, noop)
+
+	output, err := generateTypeScript(users, call)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{
+		"export class UserAdminService {",
+		"export interface UserAdminServiceGetUserArgs {\n  \"id\": number;\n  \"fields\"?: string;\n}",
+		"  getUser(args: UserAdminServiceGetUserArgs): Promise<any> {",
+		"    return this.client.call(\"user-admin\", \"get_user\", args);",
+		"  client_(args: UserAdminServiceClientArgs = {}): Promise<any> {",
+		"  readonly call_ = new CallService(this);",
+		"  readonly userAdmin = new UserAdminService(this);",
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("Output lacks %q", expected)
+		}
+	}
+}
+
+func TestGenerateTypeScriptCollisions(t *testing.T) {
+	var dashed, underscored = NewService("get-user"), NewService("get_user")
+	if _, err := generateTypeScript(dashed, underscored); err == nil {
+		t.Errorf("Colliding services accepted")
+	}
+
+	var svc = NewService("users")
+	svc.AddMethod("client", nil, noop)
+	svc.AddMethod("client_", nil, noop)
+	if _, err := generateTypeScript(svc); err == nil {
+		t.Errorf("Colliding methods accepted")
+	}
+
+	svc = NewService("users")
+	svc.AddMethod("list-all", nil, noop)
+	svc.AddMethod("listAll", nil, noop)
+	if _, err := generateTypeScript(svc); err == nil {
+		t.Errorf("Colliding methods accepted")
+	}
+}
+
+func TestGenerateTypeScriptRawPushes(t *testing.T) {
+	output, err := generateTypeScript(NewService("users"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Frames that don't decode as their prefix says are pushes
+	for _, expected := range []string{
+		"    if (!this.dispatch(message)) {\n      this.pushHandlers.forEach((handler) => handler(message));",
+		"    } catch (err) {\n      return false;\n    }",
+		"        if (typeof decoded.topic !== \"string\") {\n          return false;",
+		"        if (typeof decoded.resume !== \"string\") {\n          return false;",
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("Output lacks %q", expected)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package goservice
 
 import (
 	"sync"
+	"time"
 )
 
 
@@ -12,8 +13,22 @@ import (
 type ServerContext interface {
 	API() API
 	CreateSession(SessionConnection) Session
+	RestoreSession(*SessionRecord, SessionConnection) Session
+	SessionClosed(Session, string)
+	JoinSession(Session, SessionConnection) bool
+	ConnectionClosed(Session, SessionConnection, string)
+	Sessions() *SessionRegistry
+	Kick(string) bool
+
+	Subscribe(string, Session)
+	Unsubscribe(string, Session)
+	Publish(string, APIData) int
+
 	Log(string, ...interface{})
 	LogPrefix(string, string, ...interface{})
+	LogAt(LogLevel, string, string, ...interface{})
+	LogLevel() LogLevel
+	SetLogLevel(LogLevel)
 }
 
 type Endpoint interface {
@@ -31,12 +46,26 @@ type User interface {
 	DisplayName() string
 }
 
+// RoleUser is implemented by users that have roles or permissions,
+// checked against the Roles of restricted methods.
+type RoleUser interface {
+	Roles() []string
+}
+
 type Session interface {
 	ID() string
 	User() User
 	SetUser(User)
 
 	Send([] byte)
+	Push(APIData)
+	Close()
+
+	Get(string) (interface{}, bool)
+	Set(string, interface{})
+	Delete(string)
+
+	ConnectionInfo() ConnectionInfo
 	
 	Lock()
 	Unlock()
@@ -44,15 +73,69 @@ type Session interface {
 
 type SessionConnection interface {
 	Send([]byte)
+	Push(APIData)
+	Close()
+	Info() ConnectionInfo
 }	
 
+// ConnectionInfo describes the transport a session arrived on.
+// UserAgent is only known for HTTP and websocket connections.
+type ConnectionInfo struct {
+	Endpoint string
+	RemoteAddr string
+	UserAgent string
+}
+
 type SessionCreator func(SessionConnection) Session
 
+// ConnectedSession exposes a session's connection to endpoints that
+// need to reach it directly.
+type ConnectedSession interface {
+	Connection() SessionConnection
+}
+
+// MultiConnectionSession is implemented by sessions that can be
+// reached over several connections at once, e.g. one per browser tab
+// or device. Send and Push go to every connection; SendTo picks one.
+// RemoveConnection returns how many connections are left.
+type MultiConnectionSession interface {
+	AddConnection(SessionConnection)
+	RemoveConnection(SessionConnection) int
+	Connections() []SessionConnection
+	SendTo(SessionConnection, []byte) bool
+}
+
+// SessionObserver is told when a session's user changes; previous or
+// current is nil on login or logout respectively.
+type SessionObserver interface {
+	UserChanged(session Session, previous User, current User)
+}
+
+// ObservableSession is implemented by sessions that can report user
+// changes. The server registers itself as observer on creation.
+type ObservableSession interface {
+	SetObserver(SessionObserver)
+}
+
+// SessionHooks are called as sessions move through their lifecycle.
+// Any of them may be nil.
+type SessionHooks struct {
+	Created func(Session)
+	Login func(Session, User)
+	Logout func(Session, User)
+	Closed func(Session, string)
+}
+
 type BasicSession struct {
 	id string
 	user User
 	*sync.Mutex
-	connection SessionConnection
+	connections []SessionConnection
+	connectionLock sync.RWMutex
+	observer SessionObserver
+	attributes map[string]interface{}
+	attributeLock sync.RWMutex
+	created time.Time
 }
 
 
@@ -77,10 +160,15 @@ type APIArg struct {
 	Extra interface{}
 }
 
+// APIMethod.RequireLogin rejects calls from sessions with no User;
+// a non-empty Roles additionally requires the user to have one of
+// them. See Service.Restrict.
 type APIMethod struct {
 	Name string
 	ArgSpec []APIArg
 	Handler APIHandler
+	RequireLogin bool
+	Roles []string
 }
 
 type APIData map[string]interface{}
@@ -1,12 +1,16 @@
 package goservice
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"reflect"
 	"bytes"
 	"net"
 	"net/http"
+	"sync"
+	"time"
 
 	"github.com/ugorji/go-msgpack"
 	"code.google.com/p/go.net/websocket"
@@ -18,9 +22,46 @@ type MessageHandler func(*WebsocketEndpoint, []byte, Session, *websocket.Conn)
 type WebsocketEndpoint struct {
 	Address string
 	Handler MessageHandler
+	TextHandler MessageHandler
+
+	// Store, if set, keeps resumable sessions' records, so that a
+	// client can resume its session after a server restart as well as
+	// after a dropped connection. It has no effect without
+	// ResumeGrace. Records of sessions not resumed within IdleTimeout
+	// (DefaultSessionIdleTimeout by default) are expired; when
+	// sharing a store with a SessionTracker, give both the same
+	// IdleTimeout.
+	Store SessionStore
+	IdleTimeout time.Duration
+
+	// ResumeGrace is how long a disconnected session waits for its
+	// client to reconnect with the resume token. Zero, the default,
+	// disables resumption: no session frame is sent, and sessions
+	// close as soon as their connection does. Clients must expect the
+	// 's' frame before turning it on.
+	ResumeGrace time.Duration
+
+	// Join, if set, can return an existing session (e.g. one tracked
+	// over HTTP) for a new websocket to join instead of creating one.
+	// Since browsers send cookies with cross-site websockets, it is
+	// only consulted for connections from one of Origins, or from the
+	// endpoint's own host when Origins is empty.
+	Join func(*http.Request) Session
+	Origins []string
+
+	// Handshake, if set, vets each connection before it is accepted,
+	// e.g. JWTValidator.Handshake. An error rejects it; a User logs
+	// its session in.
+	Handshake func(*http.Request) (User, error)
 
 	listener net.Listener
 	context ServerContext
+	resumable map[string]*resumableSession
+	resumeLock sync.Mutex
+	saved map[string]string
+	storeLock sync.Mutex
+	restoreLock sync.Mutex
+	stopSweep chan bool
 }
 
 
@@ -28,24 +69,198 @@ func NewWebsocketEndpoint(address string, context ServerContext) *WebsocketEndpo
 	return &WebsocketEndpoint{
 		Address: address,
 		Handler: DefaultMessageHandler,
+		TextHandler: DefaultTextMessageHandler,
 		context: context,
+		resumable: make(map[string]*resumableSession),
+		saved: make(map[string]string),
 	}
 }
 
 
+// WebsocketSessionConnection outlives any one websocket when the
+// session is resumable. Sent messages are queued and written by the
+// connection's own goroutine, so a slow client holds up nobody else;
+// while detached, they wait for the next websocket.
 type WebsocketSessionConnection struct {
 	conn *websocket.Conn
+	info ConnectionInfo
+	queue [][]byte
+	notify chan bool
+	closed bool
+	resumeToken string
+	sync.Mutex
 }
 
+const (
+	maxQueuedWebsocketMessages = 1000
+	websocketWriteTimeout = 10 * time.Second
+)
+
+func newWebsocketSessionConnection(ws *websocket.Conn) *WebsocketSessionConnection {
+	var sessConn = &WebsocketSessionConnection{
+		conn: ws,
+		info: websocketConnectionInfo(ws),
+		notify: make(chan bool, 1),
+	}
+	go sessConn.deliver()
+	return sessConn
+}
+
+func websocketConnectionInfo(ws *websocket.Conn) ConnectionInfo {
+	return ConnectionInfo{
+		Endpoint: "websocket",
+		RemoteAddr: ws.Request().RemoteAddr,
+		UserAgent: ws.Request().UserAgent(),
+	}
+}
+
+func (sessConn *WebsocketSessionConnection) Info() ConnectionInfo {
+	sessConn.Lock()
+	defer sessConn.Unlock()
+	return sessConn.info
+}
+
+// Send queues msg, dropping the oldest message if the client has
+// fallen too far behind.
 func (sessConn *WebsocketSessionConnection) Send(msg []byte) {
-	var n, err = sessConn.conn.Write(msg)
+	sessConn.Lock()
+	defer sessConn.Unlock()
+
+	if sessConn.closed {
+		return
+	}
+
+	if len(sessConn.queue) >= maxQueuedWebsocketMessages {
+		sessConn.queue = sessConn.queue[1:]
+	}
+	sessConn.queue = append(sessConn.queue, msg)
+	sessConn.wake()
+}
+
+// wake prods the delivery goroutine. Called with the lock held.
+func (sessConn *WebsocketSessionConnection) wake() {
+	select {
+	case sessConn.notify <- true:
+	default:
+	}
+}
+
+// deliver writes queued messages to whichever websocket is attached,
+// until the connection closes. A write that fails or times out
+// closes the websocket, so its reader detaches it; the unwritten
+// messages stay queued for a resumed connection.
+func (sessConn *WebsocketSessionConnection) deliver() {
+	for range sessConn.notify {
+		for {
+			sessConn.Lock()
+			var queue, conn = sessConn.queue, sessConn.conn
+			if len(queue) == 0 || conn == nil {
+				sessConn.Unlock()
+				break
+			}
+			sessConn.queue = nil
+			sessConn.Unlock()
+
+			var written = 0
+			for _, msg := range queue {
+				if !sessConn.write(conn, msg) {
+					break
+				}
+				written++
+			}
+			if written == len(queue) {
+				continue
+			}
+
+			conn.Close()
+			sessConn.Lock()
+			if !sessConn.closed {
+				sessConn.queue = append(queue[written:], sessConn.queue...)
+			}
+			sessConn.Unlock()
+			break
+		}
+	}
+}
+
+func (sessConn *WebsocketSessionConnection) write(conn *websocket.Conn, msg []byte) bool {
+	conn.SetWriteDeadline(time.Now().Add(websocketWriteTimeout))
+	var _, err = conn.Write(msg)
 	if err != nil {
 		fmt.Printf("Websocket write error: %v\n", err)
+		return false
 	}
-	if n != len(msg) {
-		fmt.Printf("Partial websocket write: %d / %d\n", n, len(msg))
-	}		
-	fmt.Printf("Wrote %d bytes to websocket\n", n)
+	return true
+}
+
+func (sessConn *WebsocketSessionConnection) Close() {
+	sessConn.Lock()
+	defer sessConn.Unlock()
+
+	if sessConn.closed {
+		return
+	}
+	sessConn.closed = true
+	sessConn.queue = nil
+	close(sessConn.notify)
+	if sessConn.conn != nil {
+		sessConn.conn.Close()
+	}
+}
+
+// attach moves the connection onto a new websocket, closing any
+// previous one. Messages queued while detached go out first.
+func (sessConn *WebsocketSessionConnection) attach(ws *websocket.Conn) {
+	sessConn.Lock()
+	defer sessConn.Unlock()
+
+	if sessConn.conn != nil {
+		sessConn.conn.Close()
+	}
+
+	sessConn.conn = ws
+	sessConn.info = websocketConnectionInfo(ws)
+	if !sessConn.closed {
+		sessConn.wake()
+	}
+}
+
+// detach reports false if ws had already been replaced by a newer
+// websocket.
+func (sessConn *WebsocketSessionConnection) detach(ws *websocket.Conn) bool {
+	sessConn.Lock()
+	defer sessConn.Unlock()
+
+	if sessConn.conn != ws {
+		return false
+	}
+	sessConn.conn = nil
+	return true
+}
+
+func (sessConn *WebsocketSessionConnection) isClosed() bool {
+	sessConn.Lock()
+	defer sessConn.Unlock()
+	return sessConn.closed
+}
+
+func (sessConn *WebsocketSessionConnection) attached() bool {
+	sessConn.Lock()
+	defer sessConn.Unlock()
+	return sessConn.conn != nil
+}
+
+// Push sends data as a 'p'-prefixed msgpack frame, distinguishing it
+// from the 'a'-prefixed replies to calls.
+func (sessConn *WebsocketSessionConnection) Push(data APIData) {
+	w := bytes.NewBufferString("")
+	w.WriteByte('p')
+	enc := msgpack.NewEncoder(w)
+	if err := enc.Encode(data); err != nil {
+		fmt.Printf("Push encode err: %#v\n", err)
+		return
+	}
+	sessConn.Send(w.Bytes())
 }
 
 
@@ -55,6 +270,33 @@ func DefaultMessageHandler(
 	endpoint.HandleAPI(buf, session, conn)
 }
 
+func DefaultTextMessageHandler(
+	endpoint *WebsocketEndpoint, buf []byte,
+	session Session, conn *websocket.Conn) {
+	endpoint.HandleJSONRPC(buf, session, conn)
+}
+
+
+// websocketFrame carries a message along with its frame type, so
+// binary (msgpack) and text (JSON-RPC) frames can share a connection.
+type websocketFrame struct {
+	payloadType byte
+	data []byte
+}
+
+var frameCodec = websocket.Codec{
+	Marshal: func(v interface{}) ([]byte, byte, error) {
+		var frame = v.(*websocketFrame)
+		return frame.data, frame.payloadType, nil
+	},
+	Unmarshal: func(data []byte, payloadType byte, v interface{}) error {
+		var frame = v.(*websocketFrame)
+		frame.payloadType = payloadType
+		frame.data = data
+		return nil
+	},
+}
+
 
 
 func (endpoint *WebsocketEndpoint) Start() bool {
@@ -72,17 +314,56 @@ func (endpoint *WebsocketEndpoint) Start() bool {
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/favicon.ico", http.NotFound)
+	mux.Handle("/", endpoint.server())
+	go http.Serve(listener, mux)
 
-	var handler = func(ws *websocket.Conn) {
-		endpoint.Handle(ws)
+	if endpoint.storing() {
+		if endpoint.IdleTimeout == 0 {
+			endpoint.IdleTimeout = DefaultSessionIdleTimeout
+		}
+		endpoint.stopSweep = make(chan bool)
+		go endpoint.sweepStore(endpoint.stopSweep)
 	}
 
-	mux.Handle("/", websocket.Handler(handler))
-	go http.Serve(listener, mux)
-
 	return true
 }
 
+// server runs the endpoint's Handshake before accepting the
+// websocket, passing its User on to Handle in the request's context.
+func (endpoint *WebsocketEndpoint) server() http.Handler {
+	var server = websocket.Server{
+		Handler: websocket.Handler(endpoint.Handle),
+		Handshake: checkWebsocketOrigin,
+	}
+
+	return http.HandlerFunc(func(response http.ResponseWriter, req *http.Request) {
+		if endpoint.Handshake != nil {
+			user, err := endpoint.Handshake(req)
+			if err != nil {
+				fmt.Printf("Session rejected: %v\n", err)
+				http.Error(response, "Forbidden", http.StatusForbidden)
+				return
+			}
+			if user != nil {
+				req = req.WithContext(context.WithValue(req.Context(), handshakeUserKey{}, user))
+			}
+		}
+		server.ServeHTTP(response, req)
+	})
+}
+
+type handshakeUserKey struct{}
+
+// checkWebsocketOrigin keeps websocket.Handler's origin check.
+func checkWebsocketOrigin(config *websocket.Config, req *http.Request) error {
+	var err error
+	config.Origin, err = websocket.Origin(config, req)
+	if err == nil && config.Origin == nil {
+		return fmt.Errorf("null origin")
+	}
+	return err
+}
+
 
 func (endpoint *WebsocketEndpoint) Stop() bool {
 	if endpoint.listener == nil {
@@ -95,6 +376,10 @@ func (endpoint *WebsocketEndpoint) Stop() bool {
 	}
 
 	endpoint.listener = nil
+	if endpoint.stopSweep != nil {
+		close(endpoint.stopSweep)
+		endpoint.stopSweep = nil
+	}
 	return true
 }
 
@@ -105,35 +390,130 @@ func (endpoint *WebsocketEndpoint) Context() ServerContext {
 func (endpoint *WebsocketEndpoint) Handle(ws *websocket.Conn) {
 	ws.PayloadType = websocket.BinaryFrame
 
-	var buf = make([]byte, 1024 * 64)
+	var session, sessConn = endpoint.attachSession(ws)
 
-	var sessConn = &WebsocketSessionConnection{
-		conn: ws,
+	if user, _ := ws.Request().Context().Value(handshakeUserKey{}).(User); user != nil {
+		if current := session.User(); current == nil || current.ID() != user.ID() {
+			session.SetUser(user)
+		}
 	}
-	var session Session = endpoint.context.CreateSession(sessConn)
-
-	fmt.Printf("New session: %s\n", session.ID())
 
 	for {
 
-		msgLength, err := ws.Read(buf)
-		
+		var frame websocketFrame
+		err := frameCodec.Receive(ws, &frame)
+
 		if err != nil {
+			var reason = "connection closed"
 			if err != io.EOF {
 				fmt.Printf("WS error: %#v\n", err)
+				reason = fmt.Sprintf("connection error: %v", err)
 			}
-			fmt.Printf("Session closed: %s\n", session.ID())
+			endpoint.detachSession(session, sessConn, ws, reason)
 			break
 		}
 
-		if msgLength == 0 {
+		if len(frame.data) == 0 {
 			continue
 		}
 
-		var msgBuf = make([]byte, msgLength)
-		copy(msgBuf, buf)
+		if frame.payloadType == websocket.TextFrame {
+			endpoint.TextHandler(endpoint, frame.data, session, ws)
+		} else {
+			endpoint.Handler(endpoint, frame.data, session, ws)
+		}
 
-		endpoint.Handler(endpoint, msgBuf, session, ws)
+		endpoint.saveSession(session)
+	}
+}
+
+// ------------------------------------------
+// Session store
+// ------------------------------------------
+
+func (endpoint *WebsocketEndpoint) storing() bool {
+	return endpoint.Store != nil && endpoint.ResumeGrace > 0
+}
+
+// saveSession stores the session's record if it has changed since it
+// was last saved, so most frames cost no more than a comparison.
+func (endpoint *WebsocketEndpoint) saveSession(session Session) {
+	if !endpoint.storing() {
+		return
+	}
+
+	var record = NewSessionRecord(session)
+	record.ResumeTokens = endpoint.resumeTokens(session)
+
+	fingerprint, err := json.Marshal([]interface{}{
+		record.UserID, record.Attributes, record.ResumeTokens})
+	if err != nil {
+		fmt.Printf("Error encoding session %s: %v\n", session.ID(), err)
+		return
+	}
+
+	endpoint.storeLock.Lock()
+	defer endpoint.storeLock.Unlock()
+
+	if endpoint.saved[session.ID()] == string(fingerprint) {
+		return
+	}
+	if err := endpoint.Store.Save(record); err != nil {
+		fmt.Printf("Error saving session %s: %v\n", session.ID(), err)
+		return
+	}
+	endpoint.saved[session.ID()] = string(fingerprint)
+}
+
+func (endpoint *WebsocketEndpoint) forgetSession(session Session) {
+	if !endpoint.storing() {
+		return
+	}
+
+	endpoint.storeLock.Lock()
+	delete(endpoint.saved, session.ID())
+	endpoint.storeLock.Unlock()
+
+	if err := endpoint.Store.Delete(session.ID()); err != nil {
+		fmt.Printf("Error deleting session %s: %v\n", session.ID(), err)
+	}
+}
+
+// sweepStore keeps the records of this endpoint's sessions fresh and
+// expires those left behind, e.g. by clients that never came back
+// after a restart.
+func (endpoint *WebsocketEndpoint) sweepStore(stop chan bool) {
+	var ticker = time.NewTicker(sessionSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+
+		var live = make(map[string]bool)
+		endpoint.resumeLock.Lock()
+		for _, resumable := range endpoint.resumable {
+			live[resumable.session.ID()] = true
+		}
+		endpoint.resumeLock.Unlock()
+
+		for id := range live {
+			endpoint.Store.Touch(id)
+		}
+
+		expired, err := endpoint.Store.Expire(endpoint.IdleTimeout, 0)
+		if err != nil {
+			fmt.Printf("Session store expiry failed: %v\n", err)
+		}
+
+		endpoint.storeLock.Lock()
+		for _, id := range expired {
+			delete(endpoint.saved, id)
+		}
+		endpoint.storeLock.Unlock()
 	}
 }
 
@@ -172,4 +552,21 @@ func (endpoint *WebsocketEndpoint) HandleAPI(
 	}
 
 	ws.Write(w.Bytes())
-}
\ No newline at end of file
+}
+
+func (endpoint *WebsocketEndpoint) HandleJSONRPC(
+	buf []byte, session Session, ws *websocket.Conn) {
+
+	var reply = HandleJSONRPC(buf, session, endpoint.context)
+	if reply == nil {
+		return
+	}
+
+	var err = frameCodec.Send(ws, &websocketFrame{
+		payloadType: websocket.TextFrame,
+		data: reply,
+	})
+	if err != nil {
+		fmt.Printf("JSON-RPC write error: %v\n", err)
+	}
+}
@@ -0,0 +1,318 @@
+package goservice
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ugorji/go-msgpack"
+	"code.google.com/p/go.net/websocket"
+)
+
+func testWebsocketEndpoint(api API) (*WebsocketEndpoint, *httptest.Server) {
+	var harness = NewHarness(api, nil)
+	var endpoint = NewWebsocketEndpoint("", harness.Server)
+	return endpoint, httptest.NewServer(endpoint.server())
+}
+
+func dialWebsocket(t *testing.T, server *httptest.Server, query string) *websocket.Conn {
+	var url = "ws" + strings.TrimPrefix(server.URL, "http") + "/?" + query
+	ws, err := websocket.Dial(url, "", "http://localhost/")
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	return ws
+}
+
+// receiveFrame reads the next frame with the given prefix, skipping
+// others, and decodes it.
+func receiveFrame(t *testing.T, ws *websocket.Conn, prefix byte) APIData {
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		var msg []byte
+		if err := websocket.Message.Receive(ws, &msg); err != nil {
+			t.Fatalf("Receive failed waiting for '%c': %v", prefix, err)
+		}
+		if len(msg) == 0 || msg[0] != prefix {
+			continue
+		}
+
+		var data APIData
+		var resolver = msgpack.DefaultDecoderContainerResolver
+		resolver.MapType = reflect.TypeOf(make(APIData))
+		if err := msgpack.NewDecoder(bytes.NewReader(msg[1:]), &resolver).Decode(&data); err != nil {
+			t.Fatalf("Bad frame: %v", err)
+		}
+		return ToAPIData(data).(APIData)
+	}
+}
+
+func callWebsocket(t *testing.T, ws *websocket.Conn, service string, method string, data APIData) APIData {
+	var w = new(bytes.Buffer)
+	msgpack.NewEncoder(w).Encode(APIData{
+		"service": service, "method": method, "data": data, "id": 1,
+	})
+	if err := websocket.Message.Send(ws, w.Bytes()); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	return receiveFrame(t, ws, 'a')
+}
+
+func TestWebsocketCall(t *testing.T) {
+	_, server := testWebsocketEndpoint(testAPI())
+	defer server.Close()
+
+	var ws = dialWebsocket(t, server, "")
+	defer ws.Close()
+
+	var response = callWebsocket(t, ws, "test", "echo", APIData{"text": "hi"})
+	if data, _ := response["data"].(APIData); data["text"] != "hi" {
+		t.Errorf("Wrong response: %v", response)
+	}
+}
+
+// A subscriber that stops reading mustn't hold up publishing to
+// everyone else.
+func TestWebsocketStalledSubscriber(t *testing.T) {
+	var api = testAPI().(*ServiceCollection)
+	api.AddService(NewTopicService())
+	endpoint, server := testWebsocketEndpoint(api)
+	defer server.Close()
+
+	var stalled, live = dialWebsocket(t, server, ""), dialWebsocket(t, server, "")
+	defer stalled.Close()
+	defer live.Close()
+	for _, ws := range []*websocket.Conn{stalled, live} {
+		callWebsocket(t, ws, "topics", "subscribe", APIData{"topic": "news"})
+	}
+
+	var big = strings.Repeat("x", 64 * 1024)
+	var done = make(chan bool)
+	go func() {
+		for i := 0; i < 200; i++ {
+			endpoint.context.Publish("news", APIData{"n": i, "padding": big})
+		}
+		endpoint.context.Publish("news", APIData{"n": "last"})
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Publish blocked on a stalled subscriber")
+	}
+
+	for {
+		var push = receiveFrame(t, live, 'p')
+		if push["data"].(APIData)["n"] == "last" {
+			break
+		}
+	}
+}
+
+func dialWebsocketFrom(t *testing.T, server *httptest.Server, origin string) *websocket.Conn {
+	var url = "ws" + strings.TrimPrefix(server.URL, "http") + "/"
+	ws, err := websocket.Dial(url, "", origin)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	return ws
+}
+
+func TestWebsocketJoin(t *testing.T) {
+	endpoint, server := testWebsocketEndpoint(testAPI())
+	defer server.Close()
+	endpoint.ResumeGrace = time.Minute
+
+	var first = dialWebsocket(t, server, "")
+	defer first.Close()
+	var announced = receiveFrame(t, first, 's')
+
+	var second = dialWebsocket(t, server, "join=" + announced["resume"].(string))
+	defer second.Close()
+	var joined = receiveFrame(t, second, 's')
+
+	if joined["session"] != announced["session"] || joined["resume"] == announced["resume"] {
+		t.Fatalf("Wrong join: %v after %v", joined, announced)
+	}
+
+	endpoint.context.Sessions().Find(announced["session"].(string)).Push(APIData{"n": 1})
+	for _, ws := range []*websocket.Conn{first, second} {
+		if push := receiveFrame(t, ws, 'p'); push["n"] != float64(1) {
+			t.Errorf("Wrong push: %v", push)
+		}
+	}
+}
+
+// Join sees the browser's cookies, so a page from elsewhere mustn't
+// get its websocket onto the user's session.
+func TestWebsocketJoinOrigins(t *testing.T) {
+	endpoint, server := testWebsocketEndpoint(testAPI())
+	defer server.Close()
+
+	var existing = endpoint.context.CreateSession(&LoopbackConnection{})
+	var asked = make(chan bool, 1)
+	endpoint.Join = func(req *http.Request) Session {
+		asked <- true
+		return existing
+	}
+
+	var cases = []struct {
+		origins []string
+		origin string
+		joins bool
+	}{
+		{nil, server.URL, true},
+		{nil, "http://evil.example", false},
+		{[]string{"http://app.example"}, "http://app.example", true},
+		{[]string{"http://app.example"}, server.URL, false},
+	}
+
+	for _, c := range cases {
+		endpoint.Origins = c.origins
+		var ws = dialWebsocketFrom(t, server, c.origin)
+		callWebsocket(t, ws, "test", "echo", APIData{"text": "hi"})
+
+		var joined bool
+		select {
+		case joined = <-asked:
+		default:
+		}
+		if joined != c.joins {
+			t.Errorf("Origin %s with %v: joined %v", c.origin, c.origins, joined)
+		} else if joined {
+			existing.Push(APIData{"origin": c.origin})
+			if push := receiveFrame(t, ws, 'p'); push["origin"] != c.origin {
+				t.Errorf("Wrong push: %v", push)
+			}
+		}
+		ws.Close()
+	}
+}
+
+// waitDetached waits for the server to notice a resumable connection's
+// websocket has gone (or the connection has expired).
+func waitDetached(t *testing.T, endpoint *WebsocketEndpoint, token string) {
+	for i := 0; i < 200; i++ {
+		endpoint.resumeLock.Lock()
+		var resumable = endpoint.resumable[token]
+		endpoint.resumeLock.Unlock()
+		if resumable == nil || !resumable.conn.attached() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Connection never detached")
+}
+
+func TestWebsocketResume(t *testing.T) {
+	endpoint, server := testWebsocketEndpoint(testAPI())
+	defer server.Close()
+	endpoint.ResumeGrace = time.Minute
+
+	var ws = dialWebsocket(t, server, "")
+	var announced = receiveFrame(t, ws, 's')
+	var token = announced["resume"].(string)
+	ws.Close()
+	waitDetached(t, endpoint, token)
+
+	var session = endpoint.context.Sessions().Find(announced["session"].(string))
+	if session == nil {
+		t.Fatalf("Session closed within its grace period")
+	}
+	session.Push(APIData{"missed": true})
+
+	ws = dialWebsocket(t, server, "resume=" + token)
+	defer ws.Close()
+	if push := receiveFrame(t, ws, 'p'); push["missed"] != true {
+		t.Errorf("Wrong push: %v", push)
+	}
+	if resumed := receiveFrame(t, ws, 's'); resumed["session"] != announced["session"] {
+		t.Errorf("Wrong session: %v", resumed)
+	}
+}
+
+func TestWebsocketResumeExpired(t *testing.T) {
+	endpoint, server := testWebsocketEndpoint(testAPI())
+	defer server.Close()
+	endpoint.ResumeGrace = 50 * time.Millisecond
+
+	var ws = dialWebsocket(t, server, "")
+	var announced = receiveFrame(t, ws, 's')
+	ws.Close()
+	waitDetached(t, endpoint, announced["resume"].(string))
+	time.Sleep(200 * time.Millisecond)
+
+	if endpoint.context.Sessions().Find(announced["session"].(string)) != nil {
+		t.Errorf("Session outlived its grace period")
+	}
+
+	ws = dialWebsocket(t, server, "resume=" + announced["resume"].(string))
+	defer ws.Close()
+	if fresh := receiveFrame(t, ws, 's'); fresh["session"] == announced["session"] {
+		t.Errorf("Expired session resumed: %v", fresh)
+	}
+}
+
+// A resume token from before a restart brings the session back from
+// the store.
+func TestWebsocketRestore(t *testing.T) {
+	var store = NewMemorySessionStore()
+
+	endpoint, server := testWebsocketEndpoint(testAPI())
+	endpoint.Store, endpoint.ResumeGrace = store, time.Minute
+
+	var ws = dialWebsocket(t, server, "")
+	var announced = receiveFrame(t, ws, 's')
+	endpoint.context.Sessions().Find(announced["session"].(string)).Set("cart", "pizza")
+	callWebsocket(t, ws, "test", "echo", APIData{"text": "save"})
+	ws.Close()
+	server.Close()
+
+	endpoint, server = testWebsocketEndpoint(testAPI())
+	defer server.Close()
+	endpoint.Store, endpoint.ResumeGrace = store, time.Minute
+
+	ws = dialWebsocket(t, server, "resume=" + announced["resume"].(string))
+	defer ws.Close()
+	if restored := receiveFrame(t, ws, 's'); restored["session"] != announced["session"] {
+		t.Fatalf("Session not restored: %v", restored)
+	}
+
+	var session = endpoint.context.Sessions().Find(announced["session"].(string))
+	if cart, _ := session.Get("cart"); cart != "pizza" {
+		t.Errorf("Attributes not restored: %v", cart)
+	}
+
+	var forged = dialWebsocket(t, server, "resume=" + announced["session"].(string) + ".forged")
+	defer forged.Close()
+	if fresh := receiveFrame(t, forged, 's'); fresh["session"] == announced["session"] {
+		t.Errorf("Restored with a forged token")
+	}
+}
+
+func TestWebsocketHandshake(t *testing.T) {
+	var validator, _ = NewJWTValidator(JWTOptions{Secret: testJWTSecret})
+	endpoint, server := testWebsocketEndpoint(testAPI())
+	defer server.Close()
+	endpoint.Handshake = validator.Handshake
+
+	var token = signHS256(t, APIData{"alg": "HS256"}, APIData{"sub": "u1", "exp": expiresIn(time.Hour)}, testJWTSecret)
+	var ws = dialWebsocket(t, server, "access_token=" + token)
+	defer ws.Close()
+
+	var response = callWebsocket(t, ws, "test", "whoami", APIData{})
+	if data, _ := response["data"].(APIData); data["id"] != "u1" {
+		t.Errorf("Handshake user not logged in: %v", response)
+	}
+
+	var url = "ws" + strings.TrimPrefix(server.URL, "http") + "/?access_token=" + token + "x"
+	if rejected, err := websocket.Dial(url, "", "http://localhost/"); err == nil {
+		rejected.Close()
+		t.Errorf("Bad token accepted")
+	}
+}